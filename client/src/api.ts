@@ -86,8 +86,8 @@ export const verifyCode = async (data: VerifyRequest) => {
   return api.post<AuthResponse>('/auth/verify', data);
 };
 
-export const analyzeSpeech = async (text: string, sec: number, language: string) => {
-  return api.post<AnalysisData>('/analyze', { transcript: text, durationSeconds: sec, language });
+export const analyzeSpeech = async (text: string, sec: number, language: string, topicId?: number) => {
+  return api.post<AnalysisData>('/analyze', { transcript: text, durationSeconds: sec, language, topicId });
 };
 
 export const fetchHistory = async () => {
@@ -32,6 +32,7 @@ const Recorder = () => {
   const [showFillers, setShowFillers] = useState(false);
 
   const [currentTopic, setCurrentTopic] = useState<string | null>(null);
+  const [topicId, setTopicId] = useState<number | undefined>(undefined);
 
   const [isPending, startTransition] = useTransition();
   const transcriptRef = useRef<string>('');
@@ -43,6 +44,7 @@ const Recorder = () => {
     try {
       const res = await getRandomTopic(i18n.language);
       setCurrentTopic(res.data.text);
+      setTopicId(res.data.id);
       if (analysis) setAnalysis(null);
       setTranscript('');
     } catch (e) {
@@ -117,7 +119,7 @@ const Recorder = () => {
     }
     startTransition(async () => {
       try {
-        const res = await analyzeSpeech(textToAnalyze, timer, i18n.language);
+        const res = await analyzeSpeech(textToAnalyze, timer, i18n.language, topicId);
         setAnalysis(res.data);
         toast.success('Анализ готов!');
       } catch (e) {
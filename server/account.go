@@ -0,0 +1,413 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Accounts without a password or Telegram (OAuth-only) re-authenticate by
+// signing in again: the token must be at most this old.
+const accountReauthWindow = 10 * time.Minute
+
+// errAccountDeleted is returned when a write for a user finds the account
+// gone: it was deleted while the request was in flight.
+var errAccountDeleted = errors.New("account deleted")
+
+// Pending account deletion codes by user id, guarded by otpMutex. They are
+// kept apart from otpStore so handleVerify never accepts one as a login.
+var deleteOtps = map[int]*OtpSession{}
+
+type AccountProfile struct {
+	ID                int      `json:"id"`
+	Username          string   `json:"username"`
+	Email             string   `json:"email"`
+	TelegramChatID    string   `json:"telegramChatId,omitempty"`
+	OAuthProvider     string   `json:"oauthProvider,omitempty"`
+	OAuthID           string   `json:"oauthId,omitempty"`
+	XP                int      `json:"xp"`
+	Level             int      `json:"level"`
+	Streak            int      `json:"streak"`
+	LastActive        string   `json:"lastActive,omitempty"`
+	DailyTokenQuota   *int64   `json:"dailyTokenQuota,omitempty"`
+	MonthlyTokenQuota *int64   `json:"monthlyTokenQuota,omitempty"`
+	Badges            []string `json:"-"` // badges.json
+}
+
+func loadAccountProfile(uid int) (*AccountProfile, error) {
+	p := AccountProfile{ID: uid}
+	var email, tg, provider, oauthID, lastActive, badges sql.NullString
+	var daily, monthly sql.NullInt64
+	err := db.QueryRow(`
+		SELECT COALESCE(username, ''), email, telegram_chat_id, oauth_provider, oauth_id,
+			COALESCE(xp, 0), COALESCE(level, 1), COALESCE(streak, 0), last_active, badges,
+			daily_token_quota, monthly_token_quota
+		FROM users WHERE id = ?`, uid).
+		Scan(&p.Username, &email, &tg, &provider, &oauthID, &p.XP, &p.Level, &p.Streak, &lastActive, &badges, &daily, &monthly)
+	if err != nil {
+		return nil, err
+	}
+	p.Email, p.TelegramChatID, p.OAuthProvider, p.OAuthID = email.String, tg.String, provider.String, oauthID.String
+	p.LastActive = lastActive.String
+	if daily.Valid {
+		p.DailyTokenQuota = &daily.Int64
+	}
+	if monthly.Valid {
+		p.MonthlyTokenQuota = &monthly.Int64
+	}
+	p.Badges = []string{}
+	json.Unmarshal([]byte(badges.String), &p.Badges)
+	return &p, nil
+}
+
+// accountTables are exported as raw rows; the archive's other files are
+// built from the API types.
+var accountTables = []struct {
+	file  string
+	query string
+}{
+	{"scripts.json", `SELECT id, title, content, language, created_at FROM scripts WHERE user_id = ? ORDER BY id`},
+	{"personas.json", `SELECT id, name, role, tone, task, opening, temperature, created_at FROM personas WHERE user_id = ? ORDER BY id`},
+	{"training_plans.json", `SELECT id, week_start, focus, created_at FROM training_plans WHERE user_id = ? ORDER BY id`},
+	{"training_plan_items.json", `
+		SELECT i.id, i.plan_id, i.position, i.metric, i.exercise, i.topic_id, i.target, i.speech_id, i.done_at
+		FROM training_plan_items i JOIN training_plans p ON p.id = i.plan_id
+		WHERE p.user_id = ? ORDER BY i.id`},
+	{"analysis_jobs.json", `SELECT id, request, status, attempts, result, error, speech_id, created_at, updated_at FROM analysis_jobs WHERE user_id = ? ORDER BY id`},
+	{"rewarded_transcripts.json", `SELECT hash, created_at FROM rewarded_transcripts WHERE user_id = ? ORDER BY created_at`},
+	{"llm_usage.json", `SELECT feature, provider, model, prompt_tokens, response_tokens, latency_ms, success, created_at FROM llm_usage WHERE user_id = ? ORDER BY id`},
+}
+
+// writeRowsJSON streams the rows of query as a JSON array of objects keyed
+// by column name.
+func writeRowsJSON(w io.Writer, query string, args ...interface{}) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	io.WriteString(w, "[")
+	enc := json.NewEncoder(w)
+	vals := make([]interface{}, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	for n := 0; rows.Next(); n++ {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(cols))
+		for i, c := range cols {
+			if b, ok := vals[i].([]byte); ok {
+				row[c] = string(b)
+			} else {
+				row[c] = vals[i]
+			}
+		}
+		if n > 0 {
+			io.WriteString(w, ",")
+		}
+		enc.Encode(row)
+	}
+	io.WriteString(w, "]\n")
+	return rows.Err()
+}
+
+func loadCompanionSessions(uid int) ([]CompanionSession, error) {
+	rows, err := db.Query(`
+		SELECT id, mode, language, summary, speech_id, finished_at, created_at, updated_at
+		FROM companion_sessions WHERE user_id = ? ORDER BY id`, uid)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []CompanionSession{}
+	for rows.Next() {
+		var s CompanionSession
+		if err := rows.Scan(&s.ID, &s.Mode, &s.Language, &s.Summary, &s.SpeechID, &s.FinishedAt, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	rows.Close()
+
+	for i := range sessions {
+		rows, err := db.Query(`SELECT role, content, created_at FROM companion_messages WHERE session_id = ? ORDER BY id`, sessions[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		sessions[i].Messages = []CompanionMessage{}
+		for rows.Next() {
+			var m CompanionMessage
+			if err := rows.Scan(&m.Role, &m.Content, &m.CreatedAt); err != nil {
+				continue
+			}
+			sessions[i].Messages = append(sessions[i].Messages, m)
+		}
+		rows.Close()
+	}
+	return sessions, nil
+}
+
+// auditAccount records an export or deletion. The audit table has no
+// foreign key, so the record outlives the account.
+func auditAccount(ex interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, uid int, action, reauth string, details interface{}) error {
+	var d sql.NullString
+	if details != nil {
+		b, _ := json.Marshal(details)
+		d = sql.NullString{String: string(b), Valid: true}
+	}
+	_, err := ex.Exec(`INSERT INTO account_audit (user_id, action, reauth, details) VALUES (?, ?, ?, ?)`,
+		uid, action, sql.NullString{String: reauth, Valid: reauth != ""}, d)
+	return err
+}
+
+// GET /api/account/export downloads everything stored about the caller as
+// a zip of JSON files.
+func handleAccountExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, "Method not allowed", 405)
+		return
+	}
+	uid := r.Context().Value(userIDKey).(int)
+
+	profile, err := loadAccountProfile(uid)
+	if err != nil {
+		httpError(w, "User not found", 404)
+		return
+	}
+	sessions, err := loadCompanionSessions(uid)
+	if err != nil {
+		log.Println("[!] Account Export Error:", err)
+		httpError(w, "DB Query Error", 500)
+		return
+	}
+	speechRows, err := db.Query(speechSelect("s.transcript")+` WHERE s.user_id = ? ORDER BY s.created_at, s.id`, defaultLanguage, uid)
+	if err != nil {
+		log.Println("[!] Account Export Error:", err)
+		httpError(w, "DB Query Error", 500)
+		return
+	}
+	defer speechRows.Close()
+
+	if err := auditAccount(db, uid, "export", "", nil); err != nil {
+		log.Println("[!] Audit Error:", err)
+	}
+
+	filename := "orato-account-" + time.Now().UTC().Format("2006-01-02") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	// Once the archive has started, a failure can only cut it short.
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+	now := time.Now()
+	file := func(name string, write func(io.Writer) error) {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err == nil {
+			err = write(f)
+		}
+		if err != nil {
+			log.Println("[!] Account Export Error:", name, err)
+		}
+	}
+	writeJSON := func(v interface{}) func(io.Writer) error {
+		return func(f io.Writer) error {
+			enc := json.NewEncoder(f)
+			enc.SetIndent("", "  ")
+			return enc.Encode(v)
+		}
+	}
+
+	file("profile.json", writeJSON(profile))
+	file("badges.json", writeJSON(profile.Badges))
+	file("speeches.json", func(f io.Writer) error {
+		writeSpeechesJSON(f, speechIterator(speechRows))
+		return speechRows.Err()
+	})
+	file("companion_sessions.json", writeJSON(sessions))
+	for _, t := range accountTables {
+		file(t.file, func(f io.Writer) error { return writeRowsJSON(f, t.query, uid) })
+	}
+}
+
+// deleteAccount removes the user and every row that belongs to them.
+// Search index entries go with the speeches through the FTS triggers.
+// Returns how many rows each table lost.
+func deleteAccount(tx *sql.Tx, uid int) (map[string]int64, error) {
+	counts := map[string]int64{}
+	n, err := deleteSpeeches(tx, "user_id = ?", uid)
+	if err != nil {
+		return nil, err
+	}
+	counts["speeches"] = n
+
+	// Children before parents.
+	steps := []struct{ table, query string }{
+		{"companion_messages", `DELETE FROM companion_messages WHERE session_id IN (SELECT id FROM companion_sessions WHERE user_id = ?)`},
+		{"companion_sessions", `DELETE FROM companion_sessions WHERE user_id = ?`},
+		{"training_plan_items", `DELETE FROM training_plan_items WHERE plan_id IN (SELECT id FROM training_plans WHERE user_id = ?)`},
+		{"training_plans", `DELETE FROM training_plans WHERE user_id = ?`},
+		{"scripts", `DELETE FROM scripts WHERE user_id = ?`},
+		{"personas", `DELETE FROM personas WHERE user_id = ?`},
+		{"analysis_jobs", `DELETE FROM analysis_jobs WHERE user_id = ?`},
+		{"llm_usage", `DELETE FROM llm_usage WHERE user_id = ?`},
+		{"rewarded_transcripts", `DELETE FROM rewarded_transcripts WHERE user_id = ?`},
+		{"analysis_cache", `DELETE FROM analysis_cache WHERE user_id = ?`},
+		{"users", `DELETE FROM users WHERE id = ?`},
+	}
+	for _, s := range steps {
+		res, err := tx.Exec(s.query, uid)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.table, err)
+		}
+		counts[s.table], _ = res.RowsAffected()
+	}
+	return counts, nil
+}
+
+// DELETE /api/account {password, code, language} deletes the caller's
+// account for good. It takes the same proof as signing in: the password if
+// the account has one, then a Telegram code if a chat is linked (the first
+// call sends it and answers step VERIFY). Accounts with neither must have
+// signed in within accountReauthWindow.
+func handleAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		httpError(w, "Method not allowed", 405)
+		return
+	}
+	uid := r.Context().Value(userIDKey).(int)
+
+	var req struct{ Password, Code, Language string }
+	json.NewDecoder(r.Body).Decode(&req)
+
+	var hash, tgIDStr, email sql.NullString
+	if err := db.QueryRow(`SELECT password, telegram_chat_id, email FROM users WHERE id = ?`, uid).
+		Scan(&hash, &tgIDStr, &email); err != nil {
+		httpError(w, "User not found", 404)
+		return
+	}
+
+	var reauth []string
+	if hash.String != "" {
+		if bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(req.Password)) != nil {
+			httpError(w, "Неверный пароль", 400)
+			return
+		}
+		reauth = append(reauth, "password")
+	}
+
+	if tgIDStr.String != "" {
+		if req.Code == "" {
+			if _, ok := requireLanguage(w, req.Language); !ok {
+				return
+			}
+			code := fmt.Sprintf("%d", 100000+rand.Intn(900000))
+			chatID, _ := strconv.ParseInt(tgIDStr.String, 10, 64)
+			if !sendTg(chatID, code, "delete", req.Language) {
+				fmt.Printf("[!] Failed to send code to TG: %d\n", chatID)
+				httpError(w, "Ошибка связи с Telegram", 500)
+				return
+			}
+			otpMutex.Lock()
+			deleteOtps[uid] = &OtpSession{Code: code, Type: "DELETE", UserID: uid, ExpiresAt: time.Now().Add(5 * time.Minute)}
+			otpMutex.Unlock()
+			jsonResponse(w, map[string]string{"message": "Код отправлен в Telegram", "step": "VERIFY"})
+			return
+		}
+
+		otpMutex.Lock()
+		session, ok := deleteOtps[uid]
+		if !ok {
+			otpMutex.Unlock()
+			httpError(w, "Код истек", 400)
+			return
+		}
+		if time.Now().After(session.ExpiresAt) || session.Code != req.Code {
+			session.Attempts++
+			if session.Attempts > 3 {
+				delete(deleteOtps, uid)
+			}
+			otpMutex.Unlock()
+			httpError(w, "Неверный код", 400)
+			return
+		}
+		delete(deleteOtps, uid)
+		otpMutex.Unlock()
+		reauth = append(reauth, "telegram")
+	}
+
+	if len(reauth) == 0 {
+		issued, _ := r.Context().Value(tokenIssuedKey).(time.Time)
+		if time.Since(issued) > accountReauthWindow {
+			httpError(w, "Войдите заново, чтобы удалить аккаунт", 401)
+			return
+		}
+		reauth = append(reauth, "fresh_login")
+	}
+	method := strings.Join(reauth, "+")
+
+	tx, err := db.Begin()
+	if err != nil {
+		httpError(w, "Failed to delete account", 500)
+		return
+	}
+	defer tx.Rollback()
+
+	// Writing first takes SQLite's write lock for the whole transaction:
+	// queued jobs are gone before a worker can claim them, and no job
+	// changes state while we look. Speech inserts that finish later find
+	// no user and save nothing.
+	queued, err := tx.Exec(`DELETE FROM analysis_jobs WHERE user_id = ? AND status = 'queued'`, uid)
+	if err != nil {
+		log.Println("[!] Account Delete Error:", err)
+		httpError(w, "Failed to delete account", 500)
+		return
+	}
+	var running int
+	tx.QueryRow(`SELECT COUNT(*) FROM analysis_jobs WHERE user_id = ? AND status = 'running'`, uid).Scan(&running)
+	if running > 0 {
+		httpError(w, "Дождитесь окончания анализа", 409)
+		return
+	}
+
+	counts, err := deleteAccount(tx, uid)
+	if err == nil {
+		n, _ := queued.RowsAffected()
+		counts["analysis_jobs"] += n
+		err = auditAccount(tx, uid, "delete", method, counts)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("[!] Account Delete Error:", err)
+		httpError(w, "Failed to delete account", 500)
+		return
+	}
+
+	otpMutex.Lock()
+	delete(otpStore, email.String)
+	otpMutex.Unlock()
+
+	fmt.Printf("[+] Account %d deleted (%s)\n", uid, method)
+	jsonResponse(w, map[string]string{"msg": "Account deleted"})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// Deleting an account removes the cached evaluations of its speeches, which
+// quote them, but not those of other users.
+func TestDeleteAccountPurgesAnalysisCache(t *testing.T) {
+	uid := setupTestDB(t)
+	res, _ := db.Exec(`INSERT INTO users (username, email, password) VALUES ('other', 'other@example.com', '')`)
+	other, _ := res.LastInsertId()
+
+	for _, u := range []int{uid, int(other)} {
+		req := AnalyzeRequest{Transcript: fmt.Sprintf("A speech only user %d gave.", u), Duration: 20, Language: "en"}
+		if _, err := runAnalysis(context.Background(), u, req, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts, err := deleteAccount(tx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if counts["analysis_cache"] != 1 {
+		t.Errorf("deleted %d cache entries, want 1", counts["analysis_cache"])
+	}
+
+	var left int
+	db.QueryRow(`SELECT COUNT(*) FROM analysis_cache WHERE user_id = ?`, other).Scan(&left)
+	if left != 1 {
+		t.Errorf("other user's cache entries: %d, want 1", left)
+	}
+}
@@ -2,20 +2,53 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"math"
 	"net/http"
 	"strings"
-
-	"github.com/google/generative-ai-go/genai"
 )
 
-func handleAnalyze(w http.ResponseWriter, r *http.Request) {
+// decodeAnalyzeRequest reads and validates the body shared by the analyze
+// endpoints. It writes the error response itself.
+func decodeAnalyzeRequest(w http.ResponseWriter, r *http.Request, uid int) (AnalyzeRequest, bool) {
 	var req AnalyzeRequest
 	json.NewDecoder(r.Body).Decode(&req)
 
+	if len(strings.TrimSpace(req.Transcript)) < 2 {
+		httpError(w, "Нет текста", 400)
+		return req, false
+	}
+	if req.ScriptID != 0 {
+		s, err := loadScript(uid, req.ScriptID)
+		if err != nil {
+			httpError(w, "Script not found", 404)
+			return req, false
+		}
+		// A rehearsal is in the script's language unless the client says otherwise.
+		if strings.TrimSpace(req.Language) == "" {
+			req.Language = s.Language
+		}
+	}
+	if req.TopicID != 0 && !topicExists(req.TopicID) {
+		httpError(w, "Unknown topic", 400)
+		return req, false
+	}
+	if msg := validateTimings(req.Words, req.Duration); msg != "" {
+		httpError(w, msg, 400)
+		return req, false
+	}
+	lang, ok := requireLanguage(w, req.Language)
+	if !ok {
+		return req, false
+	}
+	req.Language = lang.Code
+	return req, true
+}
+
+func handleAnalyze(w http.ResponseWriter, r *http.Request) {
 	uidVal := r.Context().Value(userIDKey)
 	if uidVal == nil {
 		httpError(w, "Unauthorized", 401)
@@ -23,173 +56,211 @@ func handleAnalyze(w http.ResponseWriter, r *http.Request) {
 	}
 	uid := uidVal.(int)
 
-	if len(strings.TrimSpace(req.Transcript)) < 2 {
-		httpError(w, "Нет текста", 400)
+	req, ok := decodeAnalyzeRequest(w, r, uid)
+	if !ok || !analysisCached(req) && !checkQuota(w, uid) {
 		return
 	}
 
-	lang := req.Language
-	if lang == "" {
-		lang = "ru"
-	}
-
-	var prompt string
-	if lang == "ru" {
-		prompt = fmt.Sprintf(`
-		Роль: Судья по ораторскому мастерству. Язык: Русский.
-		Текст выступления: "%s"
-		
-		Задача: Оцени речь и верни СТРОГИЙ JSON (без Markdown).
-		
-		Структура JSON:
-		{
-			"clarityScore": (0-100, общая оценка),
-			"metrics": {
-				"confidence": (0-100, уверенность),
-				"vocabulary": (0-100, богатство языка),
-				"structure": (0-100, логика),
-				"empathy": (0-100, эмоциональность),
-				"conciseness": (0-100, краткость)
-			},
-			"fillerWords": ["слово1", "слово2"],
-			"feedback": "Похвала (1-2 предл., русский)",
-			"tip": "Совет (1-2 предл., русский)"
-		}`, req.Transcript)
-	} else {
-		prompt = fmt.Sprintf(`
-		Role: Public Speaking Coach. Language: English.
-		Speech text: "%s"
-		
-		Task: Evaluate the speech and return STRICT JSON (no Markdown).
-		
-		JSON Structure:
-		{
-			"clarityScore": (0-100, overall score),
-			"metrics": {
-				"confidence": (0-100),
-				"vocabulary": (0-100),
-				"structure": (0-100),
-				"empathy": (0-100),
-				"conciseness": (0-100)
-			},
-			"fillerWords": ["word1", "word2"],
-			"feedback": "Praise (1-2 sentences, English)",
-			"tip": "Tip (1-2 sentences, English)"
-		}`, req.Transcript)
-	}
-
-	ctx := context.Background()
-	gemini.SetTemperature(0.5)
-	resp, err := gemini.GenerateContent(ctx, genai.Text(prompt))
+	submitAnalysis(w, r, uid, req, "")
+}
 
+// submitAnalysis persists the speech as a job first, so a slow model, a
+// dropped connection or a restart never loses it. Clients that pass
+// ?async=1 poll the job; everyone else gets the result inline when it is
+// ready in time. transcript, if set, is echoed back to clients that did not
+// send one (audio uploads).
+func submitAnalysis(w http.ResponseWriter, r *http.Request, uid int, req AnalyzeRequest, transcript string) {
+	id, err := enqueueAnalysis(uid, req)
 	if err != nil {
-		log.Println("[!] Gemini Error:", err)
-		httpError(w, "Ошибка ИИ", 500)
+		log.Println("[!] Job Enqueue Error:", err)
+		httpError(w, "DB Error", 500)
 		return
 	}
 
-	if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
-		part := resp.Candidates[0].Content.Parts[0]
-		txt := fmt.Sprintf("%s", part)
-
-		s, e := strings.Index(txt, "{"), strings.LastIndex(txt, "}")
-		if s != -1 && e != -1 {
-			cleanJson := txt[s : e+1]
-
-			var result map[string]interface{}
-			if json.Unmarshal([]byte(cleanJson), &result) == nil {
-
-				if req.Duration <= 0 {
-					req.Duration = 1
-				}
-				wpm := int(math.Round(float64(len(strings.Fields(req.Transcript))) / req.Duration * 60))
-
-				clarity := 0
-				if v, ok := result["clarityScore"].(float64); ok {
-					clarity = int(v)
-				}
+	wait := analyzeSyncWait
+	if q := r.URL.Query().Get("async"); q == "1" || q == "true" {
+		wait = 0
+	}
+	job, err := waitJob(r.Context(), uid, id, wait)
+	if err != nil {
+		httpError(w, "DB Error", 500)
+		return
+	}
 
-				fwBytes, _ := json.Marshal(result["fillerWords"])
-				metricsBytes, _ := json.Marshal(result["metrics"])
+	switch job.Status {
+	case "done":
+		if job.Result != nil && transcript != "" {
+			job.Result.Transcript = transcript
+		}
+		jsonResponse(w, job.Result)
+	case "failed":
+		httpError(w, job.Error, 500)
+	default:
+		jobAccepted(w, job, transcript)
+	}
+}
 
-				fb, _ := result["feedback"].(string)
-				tp, _ := result["tip"].(string)
+// handleAnalyzeStream is the SSE variant of handleAnalyze. The speech is
+// queued as a job like any other; the first "stage" event ("queued") carries
+// its jobId, the following ones report pipeline progress (and "retry"), and
+// the final "result" event carries the same body /api/analyze returns. A
+// client that disconnects can pick the result up from the job.
+func handleAnalyzeStream(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(userIDKey).(int)
 
-				_, err := db.Exec(`INSERT INTO speeches (user_id, transcript, clarity_score, pace_wpm, filler_words, feedback, tip, metrics) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-					uid, req.Transcript, clarity, wpm, string(fwBytes), fb, tp, string(metricsBytes))
+	req, ok := decodeAnalyzeRequest(w, r, uid)
+	if !ok || !analysisCached(req) && !checkQuota(w, uid) {
+		return
+	}
 
-				if err != nil {
-					log.Println("[!] DB Save Error:", err)
-				}
+	id, err := enqueueAnalysis(uid, req)
+	if err != nil {
+		log.Println("[!] Job Enqueue Error:", err)
+		httpError(w, "DB Error", 500)
+		return
+	}
+	stages, done, stop := watchJob(id)
+	defer stop()
 
-				go processGamification(uid, clarity, wpm)
+	sse := newSSEWriter(w)
+	sse.send("stage", jobStage{Stage: "queued", Data: map[string]int64{"jobId": id}})
 
-				result["pace"] = wpm
-				jsonResponse(w, result)
-				return
-			}
+	// finished sends the outcome once the job has one.
+	finished := func() bool {
+		job, err := loadJob(uid, id)
+		if err == nil && job.Status != "done" && job.Status != "failed" {
+			return false
+		}
+		for len(stages) > 0 {
+			sse.send("stage", <-stages)
+		}
+		switch {
+		case err != nil:
+			sse.send("error", map[string]string{"error": "DB Error"})
+		case job.Status == "done":
+			sse.send("result", job.Result)
+		default:
+			sse.send("error", map[string]string{"error": job.Error})
+		}
+		return true
+	}
+	// The job may have finished before we started watching.
+	if finished() {
+		return
+	}
+	for {
+		select {
+		case s := <-stages:
+			sse.send("stage", s)
+		case <-done:
+			finished()
+			return
+		case <-r.Context().Done():
+			return
 		}
 	}
-	httpError(w, "Format Error", 500)
 }
 
-func handleCompanion(w http.ResponseWriter, r *http.Request) {
-	var req CompanionRequest
+func analysisErrorMessage(err error) string {
+	log.Println("[!] Analysis Error:", err)
+	if errors.Is(err, errInvalidAnalysis) {
+		return "Format Error"
+	}
+	return "Ошибка ИИ"
+}
 
-	if json.NewDecoder(r.Body).Decode(&req) != nil {
-		httpError(w, "Invalid JSON", 400)
-		return
+// runAnalysis is the whole analyze pipeline: local metrics, AI evaluation,
+// persistence and gamification. progress (optional) is told about each stage.
+// Nothing is saved if ctx is cancelled before the evaluation is ready.
+func runAnalysis(ctx context.Context, uid int, req AnalyzeRequest, progress func(stage string, data interface{})) (*AnalysisResult, error) {
+	if progress == nil {
+		progress = func(string, interface{}) {}
 	}
+	ctx = withUsage(ctx, uid, "analysis")
 
-	lang := req.Language
-	if lang == "" {
-		lang = "ru"
+	paceAnalysis := computePaceAnalysis(req.Words)
+	if req.Duration <= 0 && paceAnalysis != nil && len(paceAnalysis.Timeline) > 0 {
+		req.Duration = paceAnalysis.Timeline[len(paceAnalysis.Timeline)-1].End
+	}
+	if req.Duration <= 0 {
+		req.Duration = 1
 	}
+	pace := int(math.Round(float64(len(strings.Fields(req.Transcript))) / req.Duration * 60))
+	local := computeLocalMetrics(req.Transcript, req.Language)
+	progress("local_metrics", map[string]interface{}{"pace": pace, "localMetrics": local, "paceAnalysis": paceAnalysis})
 
-	ctx := context.Background()
-	var rolePrompt string
-	var temp float32
+	var rehearsal *RehearsalResult
+	if req.ScriptID != 0 {
+		// The script may have been deleted while the job was queued; the
+		// speech is still analyzed, just without the comparison.
+		if s, err := loadScript(uid, req.ScriptID); err == nil {
+			rehearsal = compareToScript(s, req.Transcript, req.Language)
+			progress("script_alignment", rehearsal)
+		} else {
+			log.Println("[!] Rehearsal Script Error:", err)
+		}
+	}
 
-	if lang == "ru" {
-		switch req.Mode {
-		case "interview":
-			rolePrompt = `Роль: Строгий HR-менеджер. Тон: Холодный. Задача: Проводи собеседование.`
-			temp = 0.3
-		case "debate":
-			rolePrompt = `Роль: Оппонент в дебатах. Тон: Напористый. Задача: Спорь и опровергай.`
-			temp = 0.9
-		default:
-			rolePrompt = `Роль: Дружелюбный наставник. Тон: Теплый. Задача: Поддерживай беседу.`
-			temp = 0.7
+	system, promptVersion, err := renderPrompt("analysis", promptData{Language: languageFor(req.Language)})
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := analysisCacheKey(req.Transcript, req.Language, promptVersion)
+	result, cached := getCachedAnalysis(cacheKey)
+	progress("ai_evaluation", map[string]bool{"cached": cached})
+	if !cached {
+		result, err = evaluateSpeech(ctx, system, req.Transcript)
+		if err != nil {
+			return nil, err
 		}
-		rolePrompt += " Язык: Русский."
-	} else {
-		switch req.Mode {
-		case "interview":
-			rolePrompt = `Role: Strict HR Manager. Tone: Cold. Task: Conduct an interview.`
-			temp = 0.3
-		case "debate":
-			rolePrompt = `Role: Debate Opponent. Tone: Assertive. Task: Argue and refute.`
-			temp = 0.9
-		default:
-			rolePrompt = `Role: Friendly Mentor. Tone: Warm. Task: Keep the conversation going.`
-			temp = 0.7
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		rolePrompt += " Language: English."
+		putCachedAnalysis(cacheKey, uid, result)
 	}
+	result.Pace = pace
+	result.LocalMetrics = local
+	result.PaceAnalysis = paceAnalysis
+	result.PromptVersion = promptVersion
+	result.Rehearsal = rehearsal
 
-	prompt := fmt.Sprintf(`%s Reply briefly (1-3 sentences). User: "%s"`, rolePrompt, req.Message)
+	fwBytes, _ := json.Marshal(result.FillerWords)
+	metricsBytes, _ := json.Marshal(result.Metrics)
+	localBytes, _ := json.Marshal(result.LocalMetrics)
+	paceBytes := []byte("{}")
+	if paceAnalysis != nil {
+		paceBytes, _ = json.Marshal(paceAnalysis)
+	}
+	var scriptID, topicID sql.NullInt64
+	var rehearsalStr sql.NullString
+	if req.TopicID != 0 {
+		topicID = sql.NullInt64{Int64: req.TopicID, Valid: true}
+	}
+	if rehearsal != nil {
+		b, _ := json.Marshal(rehearsal)
+		scriptID = sql.NullInt64{Int64: rehearsal.ScriptID, Valid: true}
+		rehearsalStr = sql.NullString{String: string(b), Valid: true}
+	}
 
-	gemini.SetTemperature(temp)
-	resp, err := gemini.GenerateContent(ctx, genai.Text(prompt))
+	// The account may have been deleted while the model was busy.
+	res, err := db.Exec(`INSERT INTO speeches (user_id, transcript, clarity_score, pace_wpm, filler_words, feedback, tip, metrics, local_metrics, pace_analysis, prompt_version, language, script_id, rehearsal, topic_id)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM users WHERE id = ?)`,
+		uid, req.Transcript, result.ClarityScore, result.Pace, string(fwBytes), result.Feedback, result.Tip, string(metricsBytes), string(localBytes), string(paceBytes), promptVersion, req.Language, scriptID, rehearsalStr, topicID, uid)
 
-	if err == nil && len(resp.Candidates) > 0 {
-		if part, ok := resp.Candidates[0].Content.Parts[0].(genai.Text); ok {
-			txt := string(part)
-			txt = strings.ReplaceAll(txt, "*", "")
-			jsonResponse(w, map[string]string{"reply": txt})
-			return
+	if err != nil {
+		log.Println("[!] DB Save Error:", err)
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		return nil, errAccountDeleted
+	} else {
+		result.ID, _ = res.LastInsertId()
+		// A resubmitted transcript is saved and scored again, but it is
+		// not new practice.
+		if firstSubmission(uid, req.Transcript) {
+			result.PlanItemsDone = completePlanItems(uid, result.ID, req.TopicID, result.Metrics)
+			progress("gamification", processGamification(uid, result.ClarityScore, result.Pace))
 		}
 	}
-	httpError(w, "AI Error", 500)
+
+	return result, nil
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// How many times we ask the model for a valid evaluation before giving up.
+const analysisMaxAttempts = 3
+
+var errInvalidAnalysis = errors.New("invalid analysis result")
+
+var analysisSchema = func() *genai.Schema {
+	score := &genai.Schema{Type: genai.TypeInteger, Description: "0-100"}
+	return &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"clarityScore": score,
+			"metrics": {
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"confidence":  score,
+					"vocabulary":  score,
+					"structure":   score,
+					"empathy":     score,
+					"conciseness": score,
+				},
+				Required: []string{"confidence", "vocabulary", "structure", "empathy", "conciseness"},
+			},
+			"fillerWords": {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+			"feedback":    {Type: genai.TypeString},
+			"tip":         {Type: genai.TypeString},
+		},
+		Required: []string{"clarityScore", "metrics", "fillerWords", "feedback", "tip"},
+	}
+}()
+
+// evaluateSpeech asks the model for an evaluation and only returns a result
+// that passed validation. Malformed output is first repaired locally (code
+// fences, surrounding prose) and then re-requested with the validation error
+// attached, at most analysisMaxAttempts times. The transcript travels as
+// delimited data, separate from the judge instructions in system.
+func evaluateSpeech(ctx context.Context, system, transcript string) (*AnalysisResult, error) {
+	req := LLMRequest{
+		System: system + "\n\n" + dataInstruction,
+		Prompt: wrapUserData(transcript),
+		Config: analysisGeneration,
+		JSON:   true,
+		Schema: analysisSchema,
+	}
+
+	var res *AnalysisResult
+	err := generateJSON(ctx, req, func(text string) (err error) {
+		res, err = parseAnalysis(text)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	guardAnalysis(res, transcript)
+	return res, nil
+}
+
+// generateJSON sends req until parse accepts the output, re-requesting with
+// the validation error attached at most analysisMaxAttempts times.
+func generateJSON(ctx context.Context, req LLMRequest, parse func(text string) error) error {
+	data := req.Prompt
+	var lastErr error
+	for attempt := 1; attempt <= analysisMaxAttempts; attempt++ {
+		resp, err := llm.Generate(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		err = parse(resp.Text)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		log.Printf("[!] Evaluation attempt %d/%d rejected: %v", attempt, analysisMaxAttempts, err)
+
+		req.Prompt = data + repairInstruction(resp.Text, err)
+	}
+	return fmt.Errorf("%w: %v", errInvalidAnalysis, lastErr)
+}
+
+func repairInstruction(previous string, cause error) string {
+	if len(previous) > 2000 {
+		previous = previous[:2000]
+	}
+	return fmt.Sprintf(`
+
+Your previous answer was rejected (%v):
+%s
+
+Return ONLY the corrected JSON object with exactly the structure from the instructions.`, cause, previous)
+}
+
+// parseAnalysis extracts, decodes and validates the model output. Missing
+// fields are an error, never a silent zero.
+func parseAnalysis(text string) (*AnalysisResult, error) {
+	raw := extractJSONObject(text)
+	if raw == "" {
+		return nil, errors.New("no JSON object found")
+	}
+
+	var in struct {
+		ClarityScore *float64 `json:"clarityScore"`
+		Metrics      *struct {
+			Confidence  *float64 `json:"confidence"`
+			Vocabulary  *float64 `json:"vocabulary"`
+			Structure   *float64 `json:"structure"`
+			Empathy     *float64 `json:"empathy"`
+			Conciseness *float64 `json:"conciseness"`
+		} `json:"metrics"`
+		FillerWords []string `json:"fillerWords"`
+		Feedback    string   `json:"feedback"`
+		Tip         string   `json:"tip"`
+	}
+	if err := json.Unmarshal([]byte(raw), &in); err != nil {
+		return nil, fmt.Errorf("malformed JSON: %v", err)
+	}
+
+	var errs []string
+	score := func(name string, v *float64) int {
+		if v == nil {
+			errs = append(errs, name+" is missing")
+			return 0
+		}
+		if *v < 0 || *v > 100 {
+			errs = append(errs, fmt.Sprintf("%s=%v is out of range 0-100", name, *v))
+			return 0
+		}
+		return int(math.Round(*v))
+	}
+
+	res := &AnalysisResult{
+		ClarityScore: score("clarityScore", in.ClarityScore),
+		FillerWords:  in.FillerWords,
+		Feedback:     strings.TrimSpace(in.Feedback),
+		Tip:          strings.TrimSpace(in.Tip),
+	}
+	if in.Metrics == nil {
+		errs = append(errs, "metrics is missing")
+	} else {
+		res.Metrics = AnalysisMetrics{
+			Confidence:  score("metrics.confidence", in.Metrics.Confidence),
+			Vocabulary:  score("metrics.vocabulary", in.Metrics.Vocabulary),
+			Structure:   score("metrics.structure", in.Metrics.Structure),
+			Empathy:     score("metrics.empathy", in.Metrics.Empathy),
+			Conciseness: score("metrics.conciseness", in.Metrics.Conciseness),
+		}
+	}
+	if res.Feedback == "" {
+		errs = append(errs, "feedback is empty")
+	}
+	if res.Tip == "" {
+		errs = append(errs, "tip is empty")
+	}
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+
+	if res.FillerWords == nil {
+		res.FillerWords = []string{}
+	}
+	return res, nil
+}
+
+// extractJSONObject strips Markdown fences and any prose around the object.
+func extractJSONObject(text string) string {
+	text = strings.TrimSpace(text)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+
+	s, e := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if s == -1 || e < s {
+		return ""
+	}
+	return text[s : e+1]
+}
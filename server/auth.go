@@ -24,6 +24,10 @@ func handleRegisterInit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, ok := requireLanguage(w, req.Language); !ok {
+		return
+	}
+
 	code := fmt.Sprintf("%d", 100000+rand.Intn(900000))
 
 	chatID, err := strconv.ParseInt(req.TelegramID, 10, 64)
@@ -32,7 +36,7 @@ func handleRegisterInit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !sendTg(chatID, code, "регистрации") {
+	if !sendTg(chatID, code, "register", req.Language) {
 		fmt.Printf("[!] Failed to send code to TG: %d\n", chatID)
 		httpError(w, "Бот не смог отправить сообщение. Напишите /start боту!", 400)
 		return
@@ -54,8 +58,12 @@ func handleRegisterInit(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleLoginInit(w http.ResponseWriter, r *http.Request) {
-	var req struct{ Email, Password string }
+	var req struct{ Email, Password, Language string }
 	json.NewDecoder(r.Body).Decode(&req)
+	// Old clients send codes we no longer know; that must not lock anyone out.
+	if _, ok := findLanguage(req.Language); !ok {
+		req.Language = defaultLanguage
+	}
 
 	var id int
 	var userHash, username, tgIDStr string
@@ -77,7 +85,7 @@ func handleLoginInit(w http.ResponseWriter, r *http.Request) {
 	code := fmt.Sprintf("%d", 100000+rand.Intn(900000))
 	chatID, _ := strconv.ParseInt(tgIDStr, 10, 64)
 
-	if !sendTg(chatID, code, "входа") {
+	if !sendTg(chatID, code, "login", req.Language) {
 		fmt.Printf("[!] Failed to send code to TG: %d\n", chatID)
 		httpError(w, "Ошибка связи с Telegram", 500)
 		return
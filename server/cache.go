@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var analysisCacheTTL = 7 * 24 * time.Hour
+
+func initAnalysisCache() {
+	if v := os.Getenv("ANALYSIS_CACHE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal("[!] Invalid ANALYSIS_CACHE_TTL: ", v)
+		}
+		analysisCacheTTL = ttl
+	}
+}
+
+// analysisCacheKey hashes everything that influences the AI evaluation:
+// the normalized transcript, language, prompt version and model. The prompt
+// version is a hash of the template, so editing a prompt never serves old
+// evaluations.
+func analysisCacheKey(transcript, lang, promptVersion string) string {
+	model := analysisGeneration.Model
+	if model == "" {
+		model = llm.DefaultModel()
+	}
+	h := sha256.New()
+	for _, part := range []string{normalizeTranscript(transcript), lang, promptVersion, llm.Name(), model} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeTranscript ignores case and whitespace, so a resubmitted speech
+// is recognized however it was re-typed or re-transcribed.
+func normalizeTranscript(transcript string) string {
+	return strings.Join(strings.Fields(strings.ToLower(transcript)), " ")
+}
+
+// firstSubmission records that the user was rewarded for this transcript
+// and reports whether it is the first time. XP, streak and plan progress
+// are given once per distinct text; the record outlives the speech, so
+// deleting and resubmitting it does not count either.
+func firstSubmission(uid int, transcript string) bool {
+	sum := sha256.Sum256([]byte(normalizeTranscript(transcript)))
+	res, err := db.Exec(`INSERT OR IGNORE INTO rewarded_transcripts (user_id, hash) VALUES (?, ?)`, uid, hex.EncodeToString(sum[:]))
+	if err != nil {
+		log.Println("[!] Reward Check Error:", err)
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n == 1
+}
+
+// analysisCached reports whether runAnalysis would answer req from the
+// cache. Such a request calls no model, so it is not held to the quota.
+func analysisCached(req AnalyzeRequest) bool {
+	_, promptVersion, err := renderPrompt("analysis", promptData{Language: languageFor(req.Language)})
+	if err != nil {
+		return false
+	}
+	_, ok := getCachedAnalysis(analysisCacheKey(req.Transcript, req.Language, promptVersion))
+	return ok
+}
+
+func getCachedAnalysis(key string) (*AnalysisResult, bool) {
+	if analysisCacheTTL <= 0 {
+		return nil, false
+	}
+	var resultStr string
+	err := db.QueryRow(`SELECT result FROM analysis_cache WHERE key = ? AND expires_at > ?`, key, time.Now().Unix()).Scan(&resultStr)
+	if err != nil {
+		return nil, false
+	}
+	var res AnalysisResult
+	if json.Unmarshal([]byte(resultStr), &res) != nil {
+		return nil, false
+	}
+	res.Cached = true
+	return &res, true
+}
+
+// putCachedAnalysis stores an evaluation with the user whose speech it is.
+// Other users who submit the same text are served from it, but it goes
+// when that user deletes the account: the feedback quotes the speech.
+func putCachedAnalysis(key string, uid int, res *AnalysisResult) {
+	if analysisCacheTTL <= 0 {
+		return
+	}
+	b, _ := json.Marshal(res)
+	now := time.Now()
+
+	_, err := db.Exec(`INSERT OR REPLACE INTO analysis_cache (key, result, expires_at, user_id) VALUES (?, ?, ?, ?)`,
+		key, string(b), now.Add(analysisCacheTTL).Unix(), uid)
+	if err != nil {
+		log.Println("[!] Cache Save Error:", err)
+	}
+	db.Exec(`DELETE FROM analysis_cache WHERE expires_at <= ?`, now.Unix())
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+)
+
+// Minimal server-side SVG charts for the printable report. They use no
+// scripts or external resources, so the HTML renders the same in a browser,
+// in print and in an HTML-to-PDF converter.
+
+const (
+	chartWidth   = 640
+	chartHeight  = 220
+	chartPadLeft = 40
+	chartPadTop  = 28
+	chartPadBot  = 36
+	chartColor   = "#6d5dfc"
+	chartGrid    = "#e3e3ea"
+	chartText    = "#555"
+)
+
+type chartPoint struct {
+	Label string
+	Value *float64 // nil leaves a gap
+}
+
+func chartOpen(b *strings.Builder, title string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" role="img" aria-label="%s" font-family="sans-serif" font-size="11">`,
+		chartWidth, chartHeight, chartWidth, chartHeight, html.EscapeString(title))
+	fmt.Fprintf(b, `<text x="%d" y="16" font-size="13" font-weight="bold" fill="#222">%s</text>`, chartPadLeft, html.EscapeString(title))
+}
+
+// chartAxes draws horizontal grid lines from 0 to yMax and returns the
+// plot area's height.
+func chartAxes(b *strings.Builder, yMax float64) float64 {
+	plotH := float64(chartHeight - chartPadTop - chartPadBot)
+	for i := 0; i <= 4; i++ {
+		y := float64(chartPadTop) + plotH*float64(i)/4
+		fmt.Fprintf(b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="%s"/>`, chartPadLeft, y, chartWidth-10, y, chartGrid)
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" text-anchor="end" fill="%s">%s</text>`, chartPadLeft-6, y+4, chartText, formatTick(yMax*float64(4-i)/4))
+	}
+	return plotH
+}
+
+func formatTick(v float64) string {
+	if v == float64(int(v)) {
+		return fmt.Sprint(int(v))
+	}
+	return fmt.Sprintf("%.1f", v)
+}
+
+// chartLabels writes at most about ten x labels so they never overlap.
+func chartLabels(b *strings.Builder, points []chartPoint, x func(i int) float64) {
+	step := max(1, (len(points)+9)/10)
+	for i := 0; i < len(points); i += step {
+		fmt.Fprintf(b, `<text x="%.1f" y="%d" text-anchor="middle" fill="%s">%s</text>`,
+			x(i), chartHeight-chartPadBot+16, chartText, html.EscapeString(points[i].Label))
+	}
+}
+
+// lineChartSVG plots values over time; runs of points are joined, missing
+// values break the line.
+func lineChartSVG(title string, points []chartPoint, yMax float64) template.HTML {
+	var b strings.Builder
+	chartOpen(&b, title)
+	plotH := chartAxes(&b, yMax)
+	plotW := float64(chartWidth - chartPadLeft - 20)
+
+	x := func(i int) float64 {
+		if len(points) == 1 {
+			return float64(chartPadLeft) + plotW/2
+		}
+		return float64(chartPadLeft+5) + plotW*float64(i)/float64(len(points)-1)
+	}
+	y := func(v float64) float64 {
+		return float64(chartPadTop) + plotH*(1-min(v, yMax)/yMax)
+	}
+
+	var run []string
+	flush := func() {
+		if len(run) > 1 {
+			fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`, chartColor, strings.Join(run, " "))
+		}
+		run = nil
+	}
+	for i, p := range points {
+		if p.Value == nil {
+			flush()
+			continue
+		}
+		run = append(run, fmt.Sprintf("%.1f,%.1f", x(i), y(*p.Value)))
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"/>`, x(i), y(*p.Value), chartColor)
+	}
+	flush()
+	chartLabels(&b, points, x)
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// barChartSVG draws one bar per point with its value above it.
+func barChartSVG(title string, points []chartPoint, yMax float64) template.HTML {
+	var b strings.Builder
+	chartOpen(&b, title)
+	plotH := chartAxes(&b, yMax)
+	plotW := float64(chartWidth - chartPadLeft - 20)
+
+	slot := plotW / float64(max(len(points), 1))
+	x := func(i int) float64 { return float64(chartPadLeft+5) + slot*(float64(i)+0.5) }
+	for i, p := range points {
+		if p.Value == nil {
+			continue
+		}
+		h := plotH * min(*p.Value, yMax) / yMax
+		top := float64(chartPadTop) + plotH - h
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s" rx="2"/>`, x(i)-slot*0.35, top, slot*0.7, h, chartColor)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle" fill="#222">%s</text>`, x(i), top-4, formatTick(round1(*p.Value)))
+	}
+	chartLabels(&b, points, x)
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// niceMax rounds a chart's upper bound up to 1, 2 or 5 times a power of ten.
+func niceMax(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	p := 1.0
+	for p*10 <= v {
+		p *= 10
+	}
+	for p > v*10 {
+		p /= 10
+	}
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*p >= v {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+func round1(v float64) float64 {
+	return float64(int(v*10+0.5)) / 10
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Prior turns sent to the model are capped at roughly this many tokens.
+// Older turns are folded into the session summary.
+const companionHistoryTokens = 3000
+
+// companionCall is a decoded chat request ready to be sent to the model.
+type companionCall struct {
+	ctx     context.Context // tagged for usage accounting
+	req     LLMRequest
+	session *CompanionSession
+	message string
+}
+
+// newCompanionCall decodes the body shared by the chat endpoints and loads
+// the session history. It writes the error response itself.
+func newCompanionCall(w http.ResponseWriter, r *http.Request) (*companionCall, bool) {
+	var req CompanionRequest
+
+	if json.NewDecoder(r.Body).Decode(&req) != nil {
+		httpError(w, "Invalid JSON", 400)
+		return nil, false
+	}
+	uid := r.Context().Value(userIDKey).(int)
+	if !checkQuota(w, uid) {
+		return nil, false
+	}
+
+	lang, ok := requireLanguage(w, req.Language)
+	if !ok {
+		return nil, false
+	}
+	// personaId replaces the old "mode"; the built-in modes are catalog
+	// personas with the same ids.
+	personaID := req.PersonaID
+	strict := personaID != ""
+	if !strict {
+		personaID = req.Mode
+	}
+
+	var session *CompanionSession
+	if req.SessionID != 0 {
+		s, err := loadCompanionSession(uid, req.SessionID)
+		if err != nil {
+			httpError(w, "Session not found", 404)
+			return nil, false
+		}
+		if s.FinishedAt != nil {
+			httpError(w, "Session already finished", 409)
+			return nil, false
+		}
+		session = s
+		personaID, lang = s.Mode, languageFor(s.Language)
+		strict = false
+	}
+
+	persona, err := findPersona(uid, personaID, lang)
+	if errors.Is(err, errPersonaNotFound) && !strict {
+		// Unknown legacy modes and sessions whose persona was deleted.
+		persona, err = findPersona(uid, defaultPersona, lang)
+	}
+	if err != nil {
+		httpError(w, "Persona not found", 404)
+		return nil, false
+	}
+
+	system, _, err := renderPrompt("companion", promptData{Language: lang, Persona: persona})
+	if err != nil {
+		log.Println("[!] Prompt Error:", err)
+		httpError(w, "AI Error", 500)
+		return nil, false
+	}
+	system += "\n\n" + dataInstruction
+	if hits := detectInjection(req.Message); len(hits) > 0 {
+		log.Printf("[!] Possible prompt injection in companion message: %q", hits)
+	}
+
+	var history []LLMMessage
+	if session != nil {
+		var summary string
+		history, summary = companionHistory(withUsage(r.Context(), uid, "companion_summary"), session)
+		if summary != "" {
+			system += "\n\nEarlier in this conversation (summary): " + summary
+		}
+	}
+
+	return &companionCall{
+		ctx: withUsage(r.Context(), uid, "companion"),
+		req: LLMRequest{
+			System:  system,
+			History: history,
+			Prompt:  wrapUserData(req.Message),
+			Config:  persona.generation(),
+		},
+		session: session,
+		message: req.Message,
+	}, true
+}
+
+// finish cleans up the model reply and records the turn in the session.
+func (c *companionCall) finish(reply string) string {
+	txt := strings.ReplaceAll(reply, "*", "")
+	if c.session != nil {
+		if err := saveCompanionTurn(c.session.ID, c.message, txt); err != nil {
+			log.Println("[!] Companion Save Error:", err)
+		}
+	}
+	return txt
+}
+
+func handleCompanion(w http.ResponseWriter, r *http.Request) {
+	call, ok := newCompanionCall(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := llm.Generate(call.ctx, call.req)
+	if err != nil {
+		log.Println("[!] LLM Error:", err)
+		httpError(w, "AI Error", 500)
+		return
+	}
+
+	jsonResponse(w, map[string]string{"reply": call.finish(resp.Text)})
+}
+
+// handleCompanionStream is the SSE variant of handleCompanion: "token" events
+// carry partial text, "done" carries the full reply. A turn interrupted by the
+// client is not saved to the session.
+func handleCompanionStream(w http.ResponseWriter, r *http.Request) {
+	call, ok := newCompanionCall(w, r)
+	if !ok {
+		return
+	}
+
+	sse := newSSEWriter(w)
+	resp, err := llm.Stream(call.ctx, call.req, func(chunk string) error {
+		return sse.send("token", map[string]string{"text": strings.ReplaceAll(chunk, "*", "")})
+	})
+	if err != nil {
+		if r.Context().Err() == nil {
+			log.Println("[!] LLM Error:", err)
+			sse.send("error", map[string]string{"error": "AI Error"})
+		}
+		return
+	}
+
+	sse.send("done", map[string]string{"reply": call.finish(resp.Text)})
+}
+
+// /api/companion/sessions: GET lists the caller's sessions, POST creates one.
+func handleCompanionSessions(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(userIDKey).(int)
+
+	switch r.Method {
+	case "POST":
+		var req struct {
+			Mode      string `json:"mode"`
+			PersonaID string `json:"personaId"`
+			Language  string `json:"language"`
+		}
+		if json.NewDecoder(r.Body).Decode(&req) != nil {
+			httpError(w, "Invalid JSON", 400)
+			return
+		}
+		if req.PersonaID == "" {
+			req.PersonaID = req.Mode
+		}
+		if req.PersonaID == "" {
+			req.PersonaID = defaultPersona
+		}
+		lang, ok := requireLanguage(w, req.Language)
+		if !ok {
+			return
+		}
+		persona, err := findPersona(uid, req.PersonaID, lang)
+		if err != nil {
+			httpError(w, "Persona not found", 404)
+			return
+		}
+
+		// The session's mode column holds the persona id.
+		res, err := db.Exec(`INSERT INTO companion_sessions (user_id, mode, language) VALUES (?, ?, ?)`, uid, persona.ID, lang.Code)
+		if err != nil {
+			httpError(w, "Failed to create session", 500)
+			return
+		}
+		id, _ := res.LastInsertId()
+		if persona.Opening != "" {
+			db.Exec(`INSERT INTO companion_messages (session_id, role, content) VALUES (?, 'model', ?)`, id, persona.Opening)
+		}
+		s, err := loadCompanionSession(uid, id)
+		if err != nil {
+			httpError(w, "Failed to create session", 500)
+			return
+		}
+		if persona.Opening != "" {
+			s.Messages = []CompanionMessage{{Role: "model", Content: persona.Opening, CreatedAt: s.CreatedAt}}
+		}
+		jsonResponse(w, s)
+
+	case "GET":
+		rows, err := db.Query(`
+			SELECT id, mode, language, summary, speech_id, finished_at, created_at, updated_at
+			FROM companion_sessions
+			WHERE user_id = ?
+			ORDER BY updated_at DESC`, uid)
+		if err != nil {
+			httpError(w, "DB Query Error", 500)
+			return
+		}
+		defer rows.Close()
+
+		res := []CompanionSession{}
+		for rows.Next() {
+			var s CompanionSession
+			if err := rows.Scan(&s.ID, &s.Mode, &s.Language, &s.Summary, &s.SpeechID, &s.FinishedAt, &s.CreatedAt, &s.UpdatedAt); err != nil {
+				continue
+			}
+			res = append(res, s)
+		}
+		jsonResponse(w, res)
+
+	default:
+		httpError(w, "Method not allowed", 405)
+	}
+}
+
+// /api/companion/sessions/{id}: GET returns the session with all messages,
+// DELETE removes it.
+func handleCompanionSession(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(userIDKey).(int)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "Invalid session id", 400)
+		return
+	}
+	s, err := loadCompanionSession(uid, id)
+	if err != nil {
+		httpError(w, "Session not found", 404)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		rows, err := db.Query(`SELECT role, content, created_at FROM companion_messages WHERE session_id = ? ORDER BY id`, s.ID)
+		if err != nil {
+			httpError(w, "DB Query Error", 500)
+			return
+		}
+		defer rows.Close()
+
+		s.Messages = []CompanionMessage{}
+		for rows.Next() {
+			var m CompanionMessage
+			if err := rows.Scan(&m.Role, &m.Content, &m.CreatedAt); err != nil {
+				continue
+			}
+			s.Messages = append(s.Messages, m)
+		}
+		jsonResponse(w, s)
+
+	case "DELETE":
+		if err := deleteCompanionSession(s.ID); err != nil {
+			httpError(w, "Failed to delete session", 500)
+			return
+		}
+		jsonResponse(w, map[string]string{"msg": "Session deleted"})
+
+	default:
+		httpError(w, "Method not allowed", 405)
+	}
+}
+
+func loadCompanionSession(userID int, id int64) (*CompanionSession, error) {
+	var s CompanionSession
+	err := db.QueryRow(`
+		SELECT id, mode, language, summary, speech_id, finished_at, created_at, updated_at
+		FROM companion_sessions
+		WHERE id = ? AND user_id = ?`, id, userID).
+		Scan(&s.ID, &s.Mode, &s.Language, &s.Summary, &s.SpeechID, &s.FinishedAt, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func deleteCompanionSession(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM companion_messages WHERE session_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM companion_sessions WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func saveCompanionTurn(sessionID int64, userText, reply string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The session is gone if its account was deleted during the call.
+	for _, m := range []struct{ role, text string }{{"user", userText}, {"model", reply}} {
+		if _, err := tx.Exec(`INSERT INTO companion_messages (session_id, role, content)
+			SELECT ?, ?, ? WHERE EXISTS (SELECT 1 FROM companion_sessions WHERE id = ?)`, sessionID, m.role, m.text, sessionID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE companion_sessions SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`, sessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type companionTurn struct {
+	id   int64
+	role string
+	text string
+}
+
+func estimateTokens(s string) int {
+	return utf8.RuneCountInString(s)/4 + 1
+}
+
+// companionHistory returns the newest turns that fit companionHistoryTokens
+// plus the running summary. Turns that no longer fit are summarized once and
+// never sent verbatim again. If summarizing fails they are simply dropped.
+func companionHistory(ctx context.Context, s *CompanionSession) ([]LLMMessage, string) {
+	var summarizedUntil int64
+	db.QueryRow(`SELECT summarized_until FROM companion_sessions WHERE id = ?`, s.ID).Scan(&summarizedUntil)
+
+	rows, err := db.Query(`SELECT id, role, content FROM companion_messages WHERE session_id = ? AND id > ? ORDER BY id`, s.ID, summarizedUntil)
+	if err != nil {
+		log.Println("[!] Companion History Error:", err)
+		return nil, s.Summary
+	}
+	var turns []companionTurn
+	for rows.Next() {
+		var t companionTurn
+		if rows.Scan(&t.id, &t.role, &t.text) == nil {
+			turns = append(turns, t)
+		}
+	}
+	rows.Close()
+
+	cut, budget := len(turns), companionHistoryTokens
+	for cut > 0 && budget-estimateTokens(turns[cut-1].text) >= 0 {
+		budget -= estimateTokens(turns[cut-1].text)
+		cut--
+	}
+	// History must open with a user turn.
+	for cut < len(turns) && turns[cut].role != "user" {
+		cut++
+	}
+
+	summary := s.Summary
+	dropped := turns[:cut]
+	// A persona's opening line precedes the first user turn. It is passed on
+	// verbatim instead of costing a summary call, until older turns have to
+	// be summarized anyway.
+	if summarizedUntil == 0 && onlyModelTurns(dropped) {
+		var opening []string
+		for _, t := range dropped {
+			opening = append(opening, t.text)
+		}
+		summary = "you opened the conversation with: " + strings.Join(opening, " ")
+		dropped = nil
+	}
+	if len(dropped) > 0 {
+		newSummary, err := summarizeTurns(ctx, summary, dropped, s.Language)
+		if err != nil {
+			log.Println("[!] Companion Summary Error:", err)
+		} else {
+			summary = newSummary
+			db.Exec(`UPDATE companion_sessions SET summary = ?, summarized_until = ? WHERE id = ?`,
+				summary, dropped[len(dropped)-1].id, s.ID)
+		}
+	}
+
+	history := make([]LLMMessage, 0, len(turns)-cut)
+	for _, t := range turns[cut:] {
+		text := t.text
+		// Earlier user turns are data just like the current message.
+		if t.role == "user" {
+			text = wrapUserData(text)
+		}
+		history = append(history, LLMMessage{Role: t.role, Text: text})
+	}
+	return history, summary
+}
+
+func onlyModelTurns(turns []companionTurn) bool {
+	if len(turns) == 0 {
+		return false
+	}
+	for _, t := range turns {
+		if t.role != "model" {
+			return false
+		}
+	}
+	return true
+}
+
+func summarizeTurns(ctx context.Context, previous string, turns []companionTurn, lang string) (string, error) {
+	var sb strings.Builder
+	if previous != "" {
+		sb.WriteString("Summary so far: " + previous + "\n\n")
+	}
+	for _, t := range turns {
+		fmt.Fprintf(&sb, "%s: %s\n", t.role, t.text)
+	}
+
+	system, _, err := renderPrompt("summary", promptData{Language: languageFor(lang)})
+	if err != nil {
+		return "", err
+	}
+	resp, err := llm.Generate(ctx, LLMRequest{
+		System: system + "\n\n" + dataInstruction,
+		Prompt: wrapUserData(sb.String()),
+		Config: GenerationConfig{Model: companionGeneration.Model, Temperature: 0.2, MaxTokens: 1024},
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(resp.Text), nil
+}
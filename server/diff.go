@@ -0,0 +1,272 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Reasons attached to changed segments of a rewrite. They are derived from
+// the words alone, not asked from the model.
+const (
+	reasonFiller     = "removed_filler"
+	reasonHedge      = "removed_hedge"
+	reasonRepetition = "removed_repetition"
+	reasonOpening    = "stronger_opening"
+	reasonTightened  = "tightened_sentence"
+	reasonWordChoice = "word_choice"
+	reasonClarified  = "clarified"
+)
+
+// Changes within this many words of the start count as the opening when the
+// first sentence is longer.
+const openingMaxWords = 20
+
+type DiffSegment struct {
+	Op        string `json:"op"` // equal | delete | insert | replace
+	Original  string `json:"original,omitempty"`
+	Rewritten string `json:"rewritten,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type diffOp struct {
+	kind byte // '=', '-', '+'
+	a, b int  // word index in original / rewritten
+}
+
+// diffTranscripts compares two texts word by word, ignoring case and
+// punctuation, and annotates every change with a reason.
+func diffTranscripts(original, rewritten, lang string) []DiffSegment {
+	a, b := strings.Fields(original), strings.Fields(rewritten)
+	ops := myersDiff(diffKeys(a), diffKeys(b))
+
+	l := languageFor(lang)
+	opening := openingLength(a)
+
+	segments := []DiffSegment{}
+	for i := 0; i < len(ops); {
+		j := i
+		if ops[i].kind == '=' {
+			for j < len(ops) && ops[j].kind == '=' {
+				j++
+			}
+			segments = append(segments, DiffSegment{
+				Op:        "equal",
+				Original:  strings.Join(a[ops[i].a:ops[j-1].a+1], " "),
+				Rewritten: strings.Join(b[ops[i].b:ops[j-1].b+1], " "),
+			})
+			i = j
+			continue
+		}
+
+		var del, ins []string
+		start := -1
+		for ; j < len(ops) && ops[j].kind != '='; j++ {
+			if ops[j].kind == '-' {
+				if start == -1 {
+					start = ops[j].a
+				}
+				del = append(del, a[ops[j].a])
+			} else {
+				ins = append(ins, b[ops[j].b])
+			}
+		}
+		if start == -1 {
+			// Pure insertion: it sits before the next original word.
+			start = len(a)
+			if j < len(ops) {
+				start = ops[j].a
+			}
+		}
+
+		seg := DiffSegment{Original: strings.Join(del, " "), Rewritten: strings.Join(ins, " ")}
+		switch {
+		case len(ins) == 0:
+			seg.Op = "delete"
+		case len(del) == 0:
+			seg.Op = "insert"
+		default:
+			seg.Op = "replace"
+		}
+		seg.Reason = changeReason(a, start, del, ins, start < opening, l)
+		segments = append(segments, seg)
+		i = j
+	}
+	return segments
+}
+
+func changeReason(a []string, start int, del, ins []string, inOpening bool, l *Language) string {
+	if len(ins) == 0 {
+		words := tokenizeWords(strings.Join(del, " "))
+		switch {
+		case coveredBy(words, l.Fillers):
+			return reasonFiller
+		case coveredBy(words, l.Hedges):
+			return reasonHedge
+		case isRepeat(a, start, len(del)):
+			return reasonRepetition
+		}
+	}
+	switch {
+	case inOpening:
+		return reasonOpening
+	case len(ins) < len(del):
+		return reasonTightened
+	case len(ins) == len(del):
+		return reasonWordChoice
+	}
+	return reasonClarified
+}
+
+// coveredBy reports whether every word belongs to a phrase of dict.
+func coveredBy(words []string, dict []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	counts, _ := countPhrases(words, dict)
+	covered := 0
+	for phrase, n := range counts {
+		covered += n * len(strings.Fields(phrase))
+	}
+	return covered == len(words)
+}
+
+// isRepeat reports whether a[start:start+n] repeats the words right before
+// or right after it ("I I think", "we need we need to").
+func isRepeat(a []string, start, n int) bool {
+	keys := diffKeys(a)
+	same := func(from int) bool {
+		if from < 0 || from+n > len(keys) {
+			return false
+		}
+		for i := 0; i < n; i++ {
+			if keys[from+i] != keys[start+i] {
+				return false
+			}
+		}
+		return true
+	}
+	return same(start-n) || same(start+n)
+}
+
+// openingLength is the number of words in the first sentence, capped at
+// openingMaxWords.
+func openingLength(words []string) int {
+	for i, w := range words {
+		if i+1 >= openingMaxWords || strings.ContainsAny(w, ".!?…") {
+			return i + 1
+		}
+	}
+	return len(words)
+}
+
+func diffKeys(words []string) []string {
+	keys := make([]string, len(words))
+	for i, w := range words {
+		keys[i] = strings.ToLower(strings.TrimFunc(w, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}))
+	}
+	return keys
+}
+
+// myersDiff is the O(ND) shortest edit script of Myers (1986) in its
+// linear-space form: each step finds the middle snake of the remaining
+// range and recurses on both halves, so memory stays O(N+M) however far
+// apart the texts are. Callers still bound N+M, since time is O((N+M)·D).
+func myersDiff(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, max(len(a), len(b)))
+	n := len(a) + len(b)
+	vf := make([]int, n+4)
+	vb := make([]int, n+4)
+
+	var walk func(a0, a1, b0, b1 int)
+	walk = func(a0, a1, b0, b1 int) {
+		for a0 < a1 && b0 < b1 && a[a0] == b[b0] {
+			ops = append(ops, diffOp{'=', a0, b0})
+			a0++
+			b0++
+		}
+		tail := 0
+		for a0 < a1-tail && b0 < b1-tail && a[a1-tail-1] == b[b1-tail-1] {
+			tail++
+		}
+		a1, b1 = a1-tail, b1-tail
+
+		switch {
+		case a0 == a1:
+			for y := b0; y < b1; y++ {
+				ops = append(ops, diffOp{'+', a0, y})
+			}
+		case b0 == b1:
+			for x := a0; x < a1; x++ {
+				ops = append(ops, diffOp{'-', x, b0})
+			}
+		default:
+			x, y, u, v := middleSnake(a[a0:a1], b[b0:b1], vf, vb)
+			walk(a0, a0+x, b0, b0+y)
+			for i := 0; i < u-x; i++ {
+				ops = append(ops, diffOp{'=', a0 + x + i, b0 + y + i})
+			}
+			walk(a0+u, a1, b0+v, b1)
+		}
+
+		for i := 0; i < tail; i++ {
+			ops = append(ops, diffOp{'=', a1 + i, b1 + i})
+		}
+	}
+	walk(0, len(a), 0, len(b))
+	return ops
+}
+
+// middleSnake runs the forward and the reverse search until they overlap
+// and returns the snake where they met, from (x, y) to (u, v). a and b are
+// non-empty; vf and vb are scratch space of at least len(a)+len(b)+4.
+func middleSnake(a, b []string, vf, vb []int) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta&1 != 0
+	maxD := (n + m + 1) / 2
+	off := maxD + 1
+	vf[off+1], vb[off+1] = 0, 0
+
+	for d := 0; d <= maxD; d++ {
+		for k := -d; k <= d; k += 2 {
+			var px int
+			if k == -d || (k != d && vf[off+k-1] < vf[off+k+1]) {
+				px = vf[off+k+1]
+			} else {
+				px = vf[off+k-1] + 1
+			}
+			py := px - k
+			cx, cy := px, py
+			for cx < n && cy < m && a[cx] == b[cy] {
+				cx++
+				cy++
+			}
+			vf[off+k] = cx
+			// The reverse search is one step behind here.
+			if kr := delta - k; odd && kr >= -(d-1) && kr <= d-1 && cx+vb[off+kr] >= n {
+				return px, py, cx, cy
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var px int
+			if k == -d || (k != d && vb[off+k-1] < vb[off+k+1]) {
+				px = vb[off+k+1]
+			} else {
+				px = vb[off+k-1] + 1
+			}
+			py := px - k
+			cx, cy := px, py
+			for cx < n && cy < m && a[n-1-cx] == b[m-1-cy] {
+				cx++
+				cy++
+			}
+			vb[off+k] = cx
+			if kf := delta - k; !odd && kf >= -d && kf <= d && cx+vf[off+kf] >= n {
+				return n - cx, m - cy, n - px, m - py
+			}
+		}
+	}
+	panic("myersDiff: no middle snake") // unreachable: the searches meet by maxD
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// applyOps checks that ops walk both sequences in order and returns the
+// number of edits.
+func applyOps(t *testing.T, a, b []string, ops []diffOp) int {
+	t.Helper()
+	x, y, edits := 0, 0, 0
+	for _, op := range ops {
+		switch op.kind {
+		case '=':
+			if op.a != x || op.b != y || a[x] != b[y] {
+				t.Fatalf("bad equal op %+v at (%d, %d)", op, x, y)
+			}
+			x++
+			y++
+		case '-':
+			if op.a != x {
+				t.Fatalf("bad delete op %+v at (%d, %d)", op, x, y)
+			}
+			x++
+			edits++
+		case '+':
+			if op.b != y {
+				t.Fatalf("bad insert op %+v at (%d, %d)", op, x, y)
+			}
+			y++
+			edits++
+		}
+	}
+	if x != len(a) || y != len(b) {
+		t.Fatalf("ops end at (%d, %d), want (%d, %d)", x, y, len(a), len(b))
+	}
+	return edits
+}
+
+func lcsLength(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else {
+				dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+			}
+		}
+	}
+	return dp[0][0]
+}
+
+func TestMyersDiffIsShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	word := func() string { return string(rune('a' + rng.Intn(3))) }
+	for i := 0; i < 2000; i++ {
+		a := make([]string, rng.Intn(12))
+		b := make([]string, rng.Intn(12))
+		for j := range a {
+			a[j] = word()
+		}
+		for j := range b {
+			b[j] = word()
+		}
+		edits := applyOps(t, a, b, myersDiff(a, b))
+		if want := len(a) + len(b) - 2*lcsLength(a, b); edits != want {
+			t.Fatalf("%v → %v: %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+func TestMyersDiffUnrelatedTexts(t *testing.T) {
+	a := make([]string, maxScriptWords)
+	b := make([]string, maxSpokenWords)
+	for i := range a {
+		a[i] = fmt.Sprint("a", i)
+	}
+	for i := range b {
+		b[i] = fmt.Sprint("b", i)
+	}
+	start := time.Now()
+	if edits := applyOps(t, a, b, myersDiff(a, b)); edits != len(a)+len(b) {
+		t.Fatalf("%d edits, want %d", edits, len(a)+len(b))
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("diff of unrelated texts took %v", d)
+	}
+}
+
+func TestDiffTranscriptsReasons(t *testing.T) {
+	cases := []struct {
+		lang, original, rewritten, reason string
+	}{
+		{"en", "So um we grew revenue last year.", "So we grew revenue last year.", reasonFiller},
+		{"en", "We grew revenue. I think it was the right call.", "We grew revenue. It was the right call.", reasonHedge},
+		{"ru", "Мы выросли. Ну это было важно для нас.", "Мы выросли. Это было важно для нас.", reasonFiller},
+		{"en", "We grew revenue. We need we need to hire.", "We grew revenue. We need to hire.", reasonRepetition},
+		{"en", "Hello everyone my name is Ann. We grew.", "Good morning my name is Ann. We grew.", reasonOpening},
+	}
+	for _, c := range cases {
+		var reasons []string
+		for _, seg := range diffTranscripts(c.original, c.rewritten, c.lang) {
+			if seg.Op != "equal" {
+				reasons = append(reasons, seg.Reason)
+			}
+		}
+		if len(reasons) != 1 || reasons[0] != c.reason {
+			t.Errorf("%q → %q: reasons %v, want [%s]", c.original, c.rewritten, reasons, c.reason)
+		}
+	}
+}
+
+func TestDiffTranscriptsRoundTrip(t *testing.T) {
+	original := "Well, um, I think we should, like, launch the product next week."
+	rewritten := "We should launch the product next week, on Monday."
+	var a, b []string
+	for _, seg := range diffTranscripts(original, rewritten, "en") {
+		if seg.Original != "" {
+			a = append(a, seg.Original)
+		}
+		if seg.Rewritten != "" {
+			b = append(b, seg.Rewritten)
+		}
+	}
+	if got := strings.Join(a, " "); got != original {
+		t.Errorf("original sides join to %q", got)
+	}
+	if got := strings.Join(b, " "); got != rewritten {
+		t.Errorf("rewritten sides join to %q", got)
+	}
+}
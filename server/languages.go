@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// defaultLanguage is used when a request does not name one.
+const defaultLanguage = "ru"
+
+// Language holds everything that differs between interface languages.
+// Adding a language means adding an entry to the languages table below,
+// its prompt templates in prompts/ and topic translations in the
+// topic_translations table.
+type Language struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`       // English name, used inside prompts
+	NativeName string `json:"nativeName"` // for language pickers
+
+	// Fillers are counted wherever they occur, so only words that are
+	// almost never meant literally belong here: "like" or "actually" would
+	// count "I like it" as a filler.
+	Fillers []string `json:"-"`
+	Hedges  []string `json:"-"`
+
+	// Profile titles for the level thresholds in titleLevels.
+	Titles []string `json:"-"`
+
+	// Telegram OTP message: action names and the line format
+	// (action, code).
+	OTPActions map[string]string `json:"-"`
+	OTPLine    string            `json:"-"`
+	OTPWarning string            `json:"-"`
+
+	// Labels of the printable history report, by key (see reportLabelKeys).
+	Report map[string]string `json:"-"`
+
+	// What the fake speech-to-text backend "hears" in this language.
+	FakeTranscript string `json:"-"`
+}
+
+// titleLevels are the levels from which Titles[i+1] applies.
+var titleLevels = []int{2, 5, 10, 20}
+
+var languages = map[string]*Language{
+	"ru": {
+		Code:       "ru",
+		Name:       "Russian",
+		NativeName: "Русский",
+		Fillers: []string{
+			"эм", "ээ", "э", "мм", "ну", "вот", "как бы", "типа", "короче", "в общем", "в общем-то",
+			"значит", "то есть", "так сказать", "это самое", "собственно", "как его", "получается", "блин",
+		},
+		Hedges: []string{
+			"наверное", "возможно", "может быть", "кажется", "мне кажется", "вроде", "вроде бы", "как будто",
+			"не уверен", "скорее всего", "пожалуй", "в каком-то смысле", "я думаю",
+		},
+		Titles:     []string{"Новичок", "Любитель", "Оратор", "Мастер Слова", "Легенда Риторики"},
+		OTPActions: map[string]string{"register": "регистрации", "login": "входа", "delete": "удаления аккаунта"},
+		OTPLine:    "Ваш код для %s: <code>%s</code>",
+		OTPWarning: "Никому не сообщайте.",
+		Report: map[string]string{
+			"title": "Orato AI — история выступлений", "generated": "Сформирован", "speeches": "Выступлений",
+			"avgClarity": "Средняя ясность", "clarityOverTime": "Ясность по неделям", "metricAverages": "Средние оценки",
+			"fillerRate": "Слова-паразиты на 100 слов", "paceDistribution": "Темп речи (слов в минуту)",
+			"date": "Дата", "clarity": "Ясность", "pace": "Темп", "fillers": "Паразиты", "feedback": "Отзыв",
+			"tip": "Совет", "notes": "Заметки", "tags": "Теги", "transcript": "Текст", "companion": "Диалог с собеседником",
+			"confidence": "Уверенность", "vocabulary": "Словарь", "structure": "Структура", "empathy": "Эмпатия",
+			"conciseness": "Лаконичность",
+		},
+		FakeTranscript: "Это тестовая расшифровка загруженной записи.",
+	},
+	"en": {
+		Code:       "en",
+		Name:       "English",
+		NativeName: "English",
+		Fillers: []string{
+			"um", "uh", "erm", "er", "ah", "hmm", "you know", "i mean", "so yeah", "okay so",
+		},
+		Hedges: []string{
+			"maybe", "perhaps", "probably", "possibly", "i think", "i guess", "i suppose", "i feel like",
+			"it seems", "somewhat", "might", "not sure", "more or less",
+		},
+		Titles:     []string{"Novice", "Amateur", "Speaker", "Master of Words", "Rhetoric Legend"},
+		OTPActions: map[string]string{"register": "registration", "login": "login", "delete": "account deletion"},
+		OTPLine:    "Your code for %s: <code>%s</code>",
+		OTPWarning: "Do not share this.",
+		Report: map[string]string{
+			"title": "Orato AI — speech history", "generated": "Generated", "speeches": "Speeches",
+			"avgClarity": "Average clarity", "clarityOverTime": "Clarity by week", "metricAverages": "Average scores",
+			"fillerRate": "Filler words per 100 words", "paceDistribution": "Pace (words per minute)",
+			"date": "Date", "clarity": "Clarity", "pace": "Pace", "fillers": "Fillers", "feedback": "Feedback",
+			"tip": "Tip", "notes": "Notes", "tags": "Tags", "transcript": "Transcript", "companion": "Companion conversation",
+			"confidence": "Confidence", "vocabulary": "Vocabulary", "structure": "Structure", "empathy": "Empathy",
+			"conciseness": "Conciseness",
+		},
+		FakeTranscript: "This is a fake transcript of the uploaded recording.",
+	},
+}
+
+// otpLanguageOrder is the order of lines in the Telegram OTP message when
+// the user's language is unknown.
+var otpLanguageOrder = []string{"en", "ru"}
+
+// normalizeLanguage lowercases a code and drops the region, so browser
+// locales like "en-US" map to "en".
+func normalizeLanguage(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		code = code[:i]
+	}
+	return code
+}
+
+// findLanguage resolves a client-supplied code; "" means defaultLanguage.
+func findLanguage(code string) (*Language, bool) {
+	if strings.TrimSpace(code) == "" {
+		return languages[defaultLanguage], true
+	}
+	l, ok := languages[normalizeLanguage(code)]
+	return l, ok
+}
+
+// languageFor is findLanguage for data that was validated earlier (stored
+// sessions, queued jobs): unknown codes fall back to the default.
+func languageFor(code string) *Language {
+	if l, ok := findLanguage(code); ok {
+		return l
+	}
+	return languages[defaultLanguage]
+}
+
+// requireLanguage resolves a request's language or answers 400. It writes
+// the error response itself.
+func requireLanguage(w http.ResponseWriter, code string) (*Language, bool) {
+	l, ok := findLanguage(code)
+	if !ok {
+		httpError(w, fmt.Sprintf("Unsupported language %q (supported: %s)", code, strings.Join(languageCodes(), ", ")), 400)
+	}
+	return l, ok
+}
+
+func languageCodes() []string {
+	codes := make([]string, 0, len(languages))
+	for c := range languages {
+		codes = append(codes, c)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func (l *Language) title(level int) string {
+	i := 0
+	for i < len(titleLevels) && level >= titleLevels[i] {
+		i++
+	}
+	return l.Titles[min(i, len(l.Titles)-1)]
+}
+
+// GET /api/languages
+func handleLanguages(w http.ResponseWriter, r *http.Request) {
+	list := make([]*Language, 0, len(languages))
+	for _, c := range languageCodes() {
+		list = append(list, languages[c])
+	}
+	jsonResponse(w, map[string]interface{}{"default": defaultLanguage, "languages": list})
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+)
+
+// LLMProvider is the only thing handlers know about the language model.
+// Implementations: Gemini, any OpenAI-compatible endpoint (OpenAI, Ollama,
+// llama.cpp server) and a deterministic fake for offline runs and CI.
+type LLMProvider interface {
+	Name() string
+	// DefaultModel is used when GenerationConfig.Model is empty.
+	DefaultModel() string
+	Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error)
+	// Stream calls onChunk with each piece of text as it arrives and returns
+	// the full response. An error from onChunk aborts the generation.
+	Stream(ctx context.Context, req LLMRequest, onChunk func(string) error) (*LLMResponse, error)
+}
+
+// System carries our instructions, Prompt carries user-supplied text only.
+// Backends must send them as separate parts so user text cannot pose as
+// instructions.
+type LLMRequest struct {
+	System  string
+	History []LLMMessage
+	Prompt  string
+	Config  GenerationConfig
+	// JSON asks the backend for a bare JSON object. Schema is honored by
+	// backends with native structured output (Gemini), others ignore it.
+	JSON   bool
+	Schema *genai.Schema
+}
+
+// LLMMessage is a prior conversation turn. Role is "user" or "model".
+type LLMMessage struct {
+	Role string
+	Text string
+}
+
+type LLMResponse struct {
+	Text string
+	// Token counts as reported by the backend (estimated by the fake).
+	PromptTokens   int
+	ResponseTokens int
+}
+
+// GenerationConfig travels with every request. Providers must not keep
+// per-call settings on shared state, so concurrent requests never see each
+// other's temperature or model. Zero values mean "backend default".
+type GenerationConfig struct {
+	Model       string
+	Temperature float32
+	MaxTokens   int32
+	TopP        float32
+}
+
+var (
+	analysisGeneration = GenerationConfig{Temperature: 0.5, MaxTokens: 4096, TopP: 0.95}
+	// Personas set their own temperature and top-p on top of this.
+	companionGeneration = GenerationConfig{Temperature: 0.7, MaxTokens: 1024, TopP: 0.95}
+)
+
+// loadGenerationOverrides lets deployments route features to different models
+// (e.g. a cheap model for chat, a stronger one for scoring).
+func loadGenerationOverrides() {
+	if m := os.Getenv("LLM_ANALYSIS_MODEL"); m != "" {
+		analysisGeneration.Model = m
+	}
+	if m := os.Getenv("LLM_COMPANION_MODEL"); m != "" {
+		companionGeneration.Model = m
+	}
+}
+
+// --- Gemini ---
+
+type geminiProvider struct {
+	client       *genai.Client
+	defaultModel string
+}
+
+func newGeminiProvider(client *genai.Client, modelName string) *geminiProvider {
+	return &geminiProvider{client: client, defaultModel: modelName}
+}
+
+func (p *geminiProvider) Name() string { return "gemini" }
+
+func (p *geminiProvider) DefaultModel() string { return p.defaultModel }
+
+// model builds a fresh GenerativeModel per call. It is a cheap value holding
+// the request settings, so nothing is shared between goroutines.
+func (p *geminiProvider) model(req LLMRequest) *genai.GenerativeModel {
+	name := req.Config.Model
+	if name == "" {
+		name = p.defaultModel
+	}
+	m := p.client.GenerativeModel(name)
+	m.SafetySettings = []*genai.SafetySetting{
+		{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockNone},
+		{Category: genai.HarmCategoryHateSpeech, Threshold: genai.HarmBlockNone},
+		{Category: genai.HarmCategorySexuallyExplicit, Threshold: genai.HarmBlockNone},
+		{Category: genai.HarmCategoryDangerousContent, Threshold: genai.HarmBlockNone},
+	}
+	m.SetTemperature(req.Config.Temperature)
+	if req.Config.MaxTokens > 0 {
+		m.SetMaxOutputTokens(req.Config.MaxTokens)
+	}
+	if req.Config.TopP > 0 {
+		m.SetTopP(req.Config.TopP)
+	}
+	if req.System != "" {
+		m.SystemInstruction = genai.NewUserContent(genai.Text(req.System))
+	}
+	if req.JSON {
+		m.ResponseMIMEType = "application/json"
+		m.ResponseSchema = req.Schema
+	}
+	return m
+}
+
+func (p *geminiProvider) chat(req LLMRequest) *genai.ChatSession {
+	cs := p.model(req).StartChat()
+	for _, m := range req.History {
+		cs.History = append(cs.History, &genai.Content{Role: m.Role, Parts: []genai.Part{genai.Text(m.Text)}})
+	}
+	return cs
+}
+
+func (p *geminiProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	resp, err := p.chat(req).SendMessage(ctx, genai.Text(req.Prompt))
+	if err != nil {
+		return nil, err
+	}
+	txt := geminiText(resp)
+	if txt == "" {
+		return nil, fmt.Errorf("gemini: empty response")
+	}
+	out := &LLMResponse{Text: txt}
+	geminiUsage(out, resp.UsageMetadata)
+	return out, nil
+}
+
+func (p *geminiProvider) Stream(ctx context.Context, req LLMRequest, onChunk func(string) error) (*LLMResponse, error) {
+	it := p.chat(req).SendMessageStream(ctx, genai.Text(req.Prompt))
+
+	var sb strings.Builder
+	out := &LLMResponse{}
+	for {
+		resp, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Every chunk carries the running totals; the last one wins.
+		geminiUsage(out, resp.UsageMetadata)
+		txt := geminiText(resp)
+		if txt == "" {
+			continue
+		}
+		sb.WriteString(txt)
+		if err := onChunk(txt); err != nil {
+			return nil, err
+		}
+	}
+	if sb.Len() == 0 {
+		return nil, fmt.Errorf("gemini: empty response")
+	}
+	out.Text = sb.String()
+	return out, nil
+}
+
+func geminiUsage(out *LLMResponse, u *genai.UsageMetadata) {
+	if u != nil {
+		out.PromptTokens = int(u.PromptTokenCount)
+		out.ResponseTokens = int(u.CandidatesTokenCount)
+	}
+}
+
+func geminiText(resp *genai.GenerateContentResponse) string {
+	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if t, ok := part.(genai.Text); ok {
+			sb.WriteString(string(t))
+		}
+	}
+	return sb.String()
+}
+
+// --- OpenAI-compatible (OpenAI, Ollama, llama.cpp, vLLM...) ---
+
+type openAIProvider struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func newOpenAIProvider(baseURL, apiKey, model string) *openAIProvider {
+	return &openAIProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		client:  &http.Client{Timeout: 120 * time.Second},
+	}
+}
+
+func (p *openAIProvider) Name() string { return "openai" }
+
+func (p *openAIProvider) DefaultModel() string { return p.model }
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// do sends a chat completion request and returns the response once the
+// status is known to be OK.
+func (p *openAIProvider) do(ctx context.Context, req LLMRequest, stream bool) (*http.Response, error) {
+	model := req.Config.Model
+	if model == "" {
+		model = p.model
+	}
+	var messages []openAIMessage
+	if req.System != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: req.System})
+	}
+	for _, m := range req.History {
+		role := m.Role
+		if role == "model" {
+			role = "assistant"
+		}
+		messages = append(messages, openAIMessage{Role: role, Content: m.Text})
+	}
+	messages = append(messages, openAIMessage{Role: "user", Content: req.Prompt})
+
+	payload := map[string]interface{}{
+		"model":       model,
+		"messages":    messages,
+		"temperature": req.Config.Temperature,
+		"stream":      stream,
+	}
+	if stream {
+		payload["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	if req.Config.MaxTokens > 0 {
+		payload["max_tokens"] = req.Config.MaxTokens
+	}
+	if req.Config.TopP > 0 {
+		payload["top_p"] = req.Config.TopP
+	}
+	if req.JSON {
+		payload["response_format"] = map[string]string{"type": "json_object"}
+	}
+	body, _ := json.Marshal(payload)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("openai: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (p *openAIProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	resp, err := p.do(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		Choices []struct {
+			Message openAIMessage `json:"message"`
+		} `json:"choices"`
+		Usage openAIUsage `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("openai: bad response: %w", err)
+	}
+	if len(data.Choices) == 0 || data.Choices[0].Message.Content == "" {
+		return nil, fmt.Errorf("openai: empty response")
+	}
+	return &LLMResponse{
+		Text:           data.Choices[0].Message.Content,
+		PromptTokens:   data.Usage.PromptTokens,
+		ResponseTokens: data.Usage.CompletionTokens,
+	}, nil
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, req LLMRequest, onChunk func(string) error) (*LLMResponse, error) {
+	resp, err := p.do(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var sb strings.Builder
+	out := &LLMResponse{}
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line, ok := strings.CutPrefix(sc.Text(), "data:")
+		if !ok {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "[DONE]" {
+			break
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta openAIMessage `json:"delta"`
+			} `json:"choices"`
+			Usage *openAIUsage `json:"usage"`
+		}
+		if json.Unmarshal([]byte(line), &chunk) != nil {
+			continue
+		}
+		if chunk.Usage != nil {
+			out.PromptTokens, out.ResponseTokens = chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		txt := chunk.Choices[0].Delta.Content
+		sb.WriteString(txt)
+		if err := onChunk(txt); err != nil {
+			return nil, err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if sb.Len() == 0 {
+		return nil, fmt.Errorf("openai: empty response")
+	}
+	out.Text = sb.String()
+	return out, nil
+}
+
+// --- Fake ---
+
+// fakeProvider never leaves the process. The same prompt always produces the
+// same answer, so tests and offline demos get stable scores.
+type fakeProvider struct{}
+
+func (fakeProvider) Name() string { return "fake" }
+
+func (fakeProvider) DefaultModel() string { return "fake" }
+
+func (f fakeProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	resp := f.generate(req)
+	resp.PromptTokens = requestTokens(req)
+	resp.ResponseTokens = estimateTokens(resp.Text)
+	return resp, nil
+}
+
+func (fakeProvider) generate(req LLMRequest) *LLMResponse {
+	h := fnv.New32a()
+	h.Write([]byte(req.System + req.Prompt))
+	seed := h.Sum32() + uint32(len(req.History))
+
+	if strings.Contains(req.System, "clarityScore") {
+		score := func(shift uint) int { return 55 + int((seed>>shift)%40) }
+		return &LLMResponse{Text: fmt.Sprintf(`{
+			"clarityScore": %d,
+			"metrics": {"confidence": %d, "vocabulary": %d, "structure": %d, "empathy": %d, "conciseness": %d},
+			"fillerWords": [],
+			"feedback": "Fake evaluation: the speech was received and scored offline.",
+			"tip": "Connect a real LLM provider to get meaningful feedback."
+		}`, score(0), score(3), score(6), score(9), score(12), score(15))}
+	}
+	if req.JSON && req.Schema != nil {
+		b, _ := json.Marshal(fakeValue(req.Schema, seed))
+		return &LLMResponse{Text: string(b)}
+	}
+
+	replies := []string{
+		"Interesting. Tell me more about that.",
+		"Why do you think so?",
+		"Can you give me a concrete example?",
+		"Good point. What would you do differently next time?",
+	}
+	return &LLMResponse{Text: replies[seed%uint32(len(replies))]}
+}
+
+// fakeValue fills a response schema: integers are scores in 55-94, strings
+// say they are fake.
+func fakeValue(s *genai.Schema, seed uint32) interface{} {
+	switch s.Type {
+	case genai.TypeObject:
+		obj := map[string]interface{}{}
+		for name, prop := range s.Properties {
+			h := fnv.New32a()
+			h.Write([]byte(name))
+			obj[name] = fakeValue(prop, seed^h.Sum32())
+		}
+		return obj
+	case genai.TypeArray:
+		return []interface{}{}
+	case genai.TypeInteger, genai.TypeNumber:
+		return 55 + int(seed%40)
+	case genai.TypeBoolean:
+		return false
+	}
+	return "Fake answer: connect a real LLM provider to get meaningful output."
+}
+
+func (f fakeProvider) Stream(ctx context.Context, req LLMRequest, onChunk func(string) error) (*LLMResponse, error) {
+	resp, err := f.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range strings.SplitAfter(resp.Text, " ") {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := onChunk(word); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
@@ -17,7 +17,15 @@ func main() {
 
 	initDB()
 	initTelegram()
-	initGemini()
+	initLLM()
+	initUsage()
+	initPersonas()
+	initPrompts()
+	initAnalysisCache()
+	initSTT()
+	initJobs()
+	initPlans()
+	initExport()
 	initOAuth()
 
 	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
@@ -34,6 +42,7 @@ func main() {
 	mux.HandleFunc("/api/auth/register-init", handleRegisterInit)
 	mux.HandleFunc("/api/auth/login-init", handleLoginInit)
 	mux.HandleFunc("/api/auth/verify", handleVerify)
+	mux.HandleFunc("/api/languages", handleLanguages)
 
 	// OAuth routes
 	mux.HandleFunc("/api/auth/google", handleGoogleAuthURL)
@@ -43,9 +52,31 @@ func main() {
 
 	// Protected routes
 	mux.HandleFunc("/api/analyze", authMiddleware(handleAnalyze))
+	mux.HandleFunc("/api/analyze/stream", authMiddleware(handleAnalyzeStream))
+	mux.HandleFunc("/api/analyze/jobs/{id}", authMiddleware(handleAnalyzeJob))
+	mux.HandleFunc("/api/speeches", authMiddleware(handleSpeeches))
+	mux.HandleFunc("/api/speeches/tags", authMiddleware(handleSpeechTags))
+	mux.HandleFunc("/api/speeches/search", authMiddleware(handleSpeechSearch))
+	mux.HandleFunc("/api/speeches/export", authMiddleware(handleSpeechExport))
+	mux.HandleFunc("/api/speeches/{id}", authMiddleware(handleSpeech))
+	mux.HandleFunc("/api/speeches/audio", authMiddleware(handleAudioUpload))
+	mux.HandleFunc("/api/speeches/{id}/rewrite", authMiddleware(handleSpeechRewrite))
+	mux.HandleFunc("/api/scripts", authMiddleware(handleScripts))
+	mux.HandleFunc("/api/scripts/{id}", authMiddleware(handleScript))
 	mux.HandleFunc("/api/companion/chat", authMiddleware(handleCompanion))
+	mux.HandleFunc("/api/companion/chat/stream", authMiddleware(handleCompanionStream))
+	mux.HandleFunc("/api/companion/sessions", authMiddleware(handleCompanionSessions))
+	mux.HandleFunc("/api/companion/sessions/{id}", authMiddleware(handleCompanionSession))
+	mux.HandleFunc("/api/companion/sessions/{id}/finish", authMiddleware(handleCompanionFinish))
+	mux.HandleFunc("/api/companion/personas", authMiddleware(handlePersonas))
+	mux.HandleFunc("/api/companion/personas/{id}", authMiddleware(handlePersona))
 	mux.HandleFunc("/api/history", authMiddleware(handleHistory))
 	mux.HandleFunc("/api/profile", authMiddleware(handleGetProfile))
+	mux.HandleFunc("/api/account", authMiddleware(handleAccount))
+	mux.HandleFunc("/api/account/export", authMiddleware(handleAccountExport))
+	mux.HandleFunc("/api/usage", authMiddleware(handleUsage))
+	mux.HandleFunc("/api/plan", authMiddleware(handlePlan))
+	mux.HandleFunc("/api/stats/progress", authMiddleware(handleProgressStats))
 	mux.HandleFunc("/api/topics/random", authMiddleware(handleGetTopic))
 
 	// CORS - more secure configuration
@@ -61,7 +92,7 @@ func main() {
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   allowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Authorization", "Content-Type"},
 		AllowCredentials: true,
 	})
@@ -27,6 +27,7 @@ type RegisterData struct {
 	Email      string `json:"email"`
 	Password   string `json:"password"`
 	TelegramID string `json:"telegramId"`
+	Language   string `json:"language,omitempty"` // language of the Telegram code message
 }
 
 type LoginRequest struct {
@@ -40,13 +41,121 @@ type VerifyRequest struct {
 }
 
 type AnalyzeRequest struct {
-	Transcript string  `json:"transcript"`
-	Duration   float64 `json:"durationSeconds"`
-	Language   string  `json:"language"`
+	Transcript string           `json:"transcript"`
+	Duration   float64          `json:"durationSeconds"`
+	Language   string           `json:"language"`
+	Words      []TranscriptWord `json:"words,omitempty"`    // optional word-level timings
+	ScriptID   int64            `json:"scriptId,omitempty"` // rehearsal of a saved script
+	TopicID    int64            `json:"topicId,omitempty"`  // topic the speech was given on
 }
 
 type CompanionRequest struct {
-	Message string `json:"message"`
-	Mode    string `json:"mode"`
-	Language string `json:"language"`
-}
\ No newline at end of file
+	Message   string `json:"message"`
+	Mode      string `json:"mode"`
+	Language  string `json:"language"`
+	SessionID int64  `json:"sessionId"`
+	PersonaID string `json:"personaId"` // catalog or custom persona; Mode is the legacy name
+}
+
+type CompanionMessage struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type CompanionSession struct {
+	ID         int64              `json:"id"`
+	Mode       string             `json:"mode"` // persona id
+	Language   string             `json:"language"`
+	Summary    string             `json:"summary,omitempty"`
+	SpeechID   *int64             `json:"speechId,omitempty"` // scorecard, once finished
+	FinishedAt *time.Time         `json:"finishedAt,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+	Messages   []CompanionMessage `json:"messages,omitempty"`
+}
+
+type AnalysisMetrics struct {
+	Confidence  int `json:"confidence"`
+	Vocabulary  int `json:"vocabulary"`
+	Structure   int `json:"structure"`
+	Empathy     int `json:"empathy"`
+	Conciseness int `json:"conciseness"`
+}
+
+type AnalysisResult struct {
+	ID            int64            `json:"id,omitempty"` // speech id once saved
+	ClarityScore  int              `json:"clarityScore"`
+	Metrics       AnalysisMetrics  `json:"metrics"`
+	FillerWords   []string         `json:"fillerWords"`
+	Feedback      string           `json:"feedback"`
+	Tip           string           `json:"tip"`
+	Pace          int              `json:"pace"`
+	LocalMetrics  *LocalMetrics    `json:"localMetrics,omitempty"`
+	PaceAnalysis  *PaceAnalysis    `json:"paceAnalysis,omitempty"`
+	Suspicious    bool             `json:"suspicious,omitempty"`
+	Cached        bool             `json:"cached"`
+	Transcript    string           `json:"transcript,omitempty"`
+	PromptVersion string           `json:"promptVersion,omitempty"` // template that produced the evaluation
+	Rehearsal     *RehearsalResult `json:"rehearsal,omitempty"`
+	PlanItemsDone []int64          `json:"planItemsDone,omitempty"` // training plan items this speech completed
+}
+
+type Scorecard struct {
+	ID            int64                `json:"id"` // speech id
+	SessionID     int64                `json:"sessionId"`
+	PersonaID     string               `json:"personaId"`
+	OverallScore  int                  `json:"overallScore"`
+	Criteria      []ScorecardCriterion `json:"criteria"`
+	Feedback      string               `json:"feedback"`
+	Tip           string               `json:"tip"`
+	LocalMetrics  *LocalMetrics        `json:"localMetrics"` // user's turns only
+	Suspicious    bool                 `json:"suspicious,omitempty"`
+	PromptVersion string               `json:"promptVersion"`
+	Gamification  *GamificationResult  `json:"gamification,omitempty"`
+}
+
+type ScorecardCriterion struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Score       int    `json:"score"`
+}
+
+type RewriteResult struct {
+	SpeechID      int64          `json:"speechId"`
+	Original      string         `json:"original"`
+	Rewritten     string         `json:"rewritten"`
+	Diff          []DiffSegment  `json:"diff"`
+	Changes       map[string]int `json:"changes"` // changed segments per reason
+	Before        *LocalMetrics  `json:"before"`
+	After         *LocalMetrics  `json:"after"`
+	PromptVersion string         `json:"promptVersion"`
+	Cached        bool           `json:"cached"`
+}
+
+type Script struct {
+	ID        int64     `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content,omitempty"`
+	Language  string    `json:"language"`
+	Words     int       `json:"words"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type GamificationResult struct {
+	EarnedXP  int      `json:"earnedXp"`
+	XP        int      `json:"xp"`
+	Level     int      `json:"level"`
+	Streak    int      `json:"streak"`
+	NewBadges []string `json:"newBadges"`
+}
+
+type AnalysisJob struct {
+	ID        int64           `json:"id"`
+	Status    string          `json:"status"` // queued | running | done | failed
+	Attempts  int             `json:"attempts"`
+	Result    *AnalysisResult `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
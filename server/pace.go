@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// Gaps between words shorter than this are normal articulation.
+	minPauseSeconds = 0.5
+	// Width of one point on the WPM timeline.
+	paceWindowSeconds = 10.0
+	// Longest recording we accept timings for, and at most this many timed
+	// words (far beyond anyone talking for maxSpeechSeconds).
+	maxSpeechSeconds = 4 * 60 * 60.0
+	maxTimedWords    = 60000
+	maxPacePoints    = int(maxSpeechSeconds / paceWindowSeconds)
+)
+
+// validateTimings checks client-supplied timings: the duration and every
+// word timestamp must be finite and within the recording. It returns a
+// message for a 400, or "".
+func validateTimings(words []TranscriptWord, duration float64) string {
+	if math.IsNaN(duration) || math.IsInf(duration, 0) || duration < 0 || duration > maxSpeechSeconds {
+		return "Invalid duration"
+	}
+	if len(words) > maxTimedWords {
+		return fmt.Sprintf("Too many words (max %d)", maxTimedWords)
+	}
+	limit := maxSpeechSeconds
+	if duration > 0 {
+		limit = duration + 1 // rounding in STT output
+	}
+	for _, w := range words {
+		for _, t := range []float64{w.Start, w.End} {
+			if math.IsNaN(t) || math.IsInf(t, 0) || t < 0 || t > limit {
+				return "Invalid word timestamps"
+			}
+		}
+	}
+	return ""
+}
+
+type PaceAnalysis struct {
+	Timeline       []PacePoint   `json:"timeline"`
+	Pauses         []PauseBucket `json:"pauses"`
+	PauseCount     int           `json:"pauseCount"`
+	TotalPause     float64       `json:"totalPause"`
+	LongestPause   float64       `json:"longestPause"`
+	LongestPauseAt float64       `json:"longestPauseAt"` // seconds from start
+}
+
+type PacePoint struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	WPM   int     `json:"wpm"`
+}
+
+type PauseBucket struct {
+	Label string  `json:"label"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"` // 0 = open-ended
+	Count int     `json:"count"`
+}
+
+func newPauseBuckets() []PauseBucket {
+	return []PauseBucket{
+		{Label: "0.5-1s", Min: 0.5, Max: 1},
+		{Label: "1-2s", Min: 1, Max: 2},
+		{Label: "2-5s", Min: 2, Max: 5},
+		{Label: "5s+", Min: 5},
+	}
+}
+
+// computePaceAnalysis builds the WPM timeline and pause statistics from
+// word timestamps. Words with broken timings are dropped rather than
+// rejected, STT engines occasionally emit them.
+func computePaceAnalysis(words []TranscriptWord) *PaceAnalysis {
+	valid := make([]TranscriptWord, 0, len(words))
+	for _, w := range words {
+		if w.Start >= 0 && w.End >= w.Start && w.End <= maxSpeechSeconds {
+			valid = append(valid, w)
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	sort.SliceStable(valid, func(i, j int) bool { return valid[i].Start < valid[j].Start })
+
+	pa := &PaceAnalysis{Timeline: []PacePoint{}, Pauses: newPauseBuckets()}
+
+	for i := 1; i < len(valid); i++ {
+		gap := valid[i].Start - valid[i-1].End
+		if gap < minPauseSeconds {
+			continue
+		}
+		pa.PauseCount++
+		pa.TotalPause += gap
+		if gap > pa.LongestPause {
+			pa.LongestPause, pa.LongestPauseAt = gap, valid[i-1].End
+		}
+		for b := range pa.Pauses {
+			if gap >= pa.Pauses[b].Min && (pa.Pauses[b].Max == 0 || gap < pa.Pauses[b].Max) {
+				pa.Pauses[b].Count++
+				break
+			}
+		}
+	}
+
+	end := valid[len(valid)-1].End
+	for start, i := 0.0, 0; start < end && len(pa.Timeline) < maxPacePoints; start += paceWindowSeconds {
+		stop := math.Min(start+paceWindowSeconds, end)
+		n := 0
+		for ; i < len(valid) && valid[i].Start < stop; i++ {
+			n++
+		}
+		length := math.Max(stop-start, 1)
+		pa.Timeline = append(pa.Timeline, PacePoint{
+			Start: round3(start),
+			End:   round3(stop),
+			WPM:   int(math.Round(float64(n) / length * 60)),
+		})
+	}
+
+	pa.TotalPause = round3(pa.TotalPause)
+	pa.LongestPause = round3(pa.LongestPause)
+	pa.LongestPauseAt = round3(pa.LongestPauseAt)
+	return pa
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The built-in persona catalog. PERSONAS_FILE replaces it with a file of
+// the same shape.
+//
+//go:embed personas.json
+var embeddedPersonas []byte
+
+const (
+	defaultPersona = "mentor"
+	// Custom persona ids are "custom-<row id>", built-in ones are words.
+	customPersonaPrefix = "custom-"
+	maxCustomPersonas   = 20
+)
+
+// personaConfig is one catalog entry: generation settings, the texts in
+// every registered language and the rubric the finished conversation is
+// graded against.
+type personaConfig struct {
+	ID          string                 `json:"id"`
+	Temperature float32                `json:"temperature"`
+	TopP        float32                `json:"topP,omitempty"`
+	Languages   map[string]PersonaText `json:"languages"`
+	Rubric      []struct {
+		ID        string            `json:"id"`
+		Languages map[string]string `json:"languages"`
+	} `json:"rubric"`
+}
+
+type PersonaText struct {
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	Tone    string `json:"tone,omitempty"`
+	Task    string `json:"task,omitempty"`
+	Opening string `json:"opening,omitempty"` // first model message of a new session
+}
+
+// Persona is a catalog entry resolved for one language, or a user's own.
+// Custom personas are graded with the default persona's rubric.
+type Persona struct {
+	ID string `json:"id"`
+	PersonaText
+	Temperature float32           `json:"temperature"`
+	TopP        float32           `json:"-"`
+	Custom      bool              `json:"custom"`
+	Rubric      []RubricCriterion `json:"rubric,omitempty"`
+}
+
+type RubricCriterion struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+}
+
+var (
+	personaCatalog []personaConfig
+	personaByID    = map[string]*personaConfig{}
+)
+
+var errPersonaNotFound = errors.New("persona not found")
+
+func initPersonas() {
+	data, source := embeddedPersonas, "embedded"
+	if file := os.Getenv("PERSONAS_FILE"); file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatal("[!] Personas File Error: ", err)
+		}
+		data, source = b, file
+	}
+
+	if err := json.Unmarshal(data, &personaCatalog); err != nil {
+		log.Fatal("[!] Personas Parse Error: ", err)
+	}
+	for i := range personaCatalog {
+		p := &personaCatalog[i]
+		if err := validatePersonaConfig(p); err != nil {
+			log.Fatal("[!] Invalid persona ", p.ID, ": ", err)
+		}
+		personaByID[p.ID] = p
+	}
+	if personaByID[defaultPersona] == nil {
+		log.Fatal("[!] Persona catalog has no ", defaultPersona, " persona")
+	}
+
+	fmt.Printf("[+] Personas: %d (%s)\n", len(personaCatalog), source)
+}
+
+func validatePersonaConfig(p *personaConfig) error {
+	if p.ID == "" || strings.HasPrefix(p.ID, customPersonaPrefix) {
+		return fmt.Errorf("invalid id %q", p.ID)
+	}
+	if personaByID[p.ID] != nil {
+		return errors.New("duplicate id")
+	}
+	if p.Temperature < 0 || p.Temperature > 2 {
+		return errors.New("temperature must be 0-2")
+	}
+	for _, code := range languageCodes() {
+		t, ok := p.Languages[code]
+		if !ok || t.Name == "" || t.Role == "" {
+			return fmt.Errorf("missing name or role for language %s", code)
+		}
+	}
+	if len(p.Rubric) == 0 {
+		return errors.New("empty rubric")
+	}
+	seen := map[string]bool{}
+	for _, c := range p.Rubric {
+		if c.ID == "" || seen[c.ID] {
+			return fmt.Errorf("invalid or duplicate rubric id %q", c.ID)
+		}
+		seen[c.ID] = true
+		for _, code := range languageCodes() {
+			if c.Languages[code] == "" {
+				return fmt.Errorf("rubric %s has no text for language %s", c.ID, code)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *personaConfig) resolve(lang *Language) *Persona {
+	p := &Persona{ID: c.ID, PersonaText: c.Languages[lang.Code], Temperature: c.Temperature, TopP: c.TopP}
+	for _, r := range c.Rubric {
+		p.Rubric = append(p.Rubric, RubricCriterion{ID: r.ID, Description: r.Languages[lang.Code]})
+	}
+	return p
+}
+
+// findPersona looks up a catalog persona or one of the user's own.
+func findPersona(uid int, id string, lang *Language) (*Persona, error) {
+	if c := personaByID[id]; c != nil {
+		return c.resolve(lang), nil
+	}
+	rowID, err := strconv.ParseInt(strings.TrimPrefix(id, customPersonaPrefix), 10, 64)
+	if !strings.HasPrefix(id, customPersonaPrefix) || err != nil {
+		return nil, errPersonaNotFound
+	}
+
+	p := Persona{ID: id, Custom: true, Rubric: personaByID[defaultPersona].resolve(lang).Rubric}
+	err = db.QueryRow(`SELECT name, role, tone, task, opening, temperature FROM personas WHERE id = ? AND user_id = ?`, rowID, uid).
+		Scan(&p.Name, &p.Role, &p.Tone, &p.Task, &p.Opening, &p.Temperature)
+	if err == sql.ErrNoRows {
+		return nil, errPersonaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// generation is companionGeneration with the persona's sampling settings.
+func (p *Persona) generation() GenerationConfig {
+	cfg := companionGeneration
+	cfg.Temperature = p.Temperature
+	if p.TopP > 0 {
+		cfg.TopP = p.TopP
+	}
+	return cfg
+}
+
+// validate checks a user-defined persona. Its texts end up in the system
+// prompt, so they get the same injection screening as transcripts.
+func (p *Persona) validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Role = strings.TrimSpace(p.Role)
+	p.Tone = strings.TrimSpace(p.Tone)
+	p.Task = strings.TrimSpace(p.Task)
+	p.Opening = strings.TrimSpace(p.Opening)
+
+	fields := []struct {
+		name, value string
+		required    bool
+		max         int
+	}{
+		{"name", p.Name, true, 60},
+		{"role", p.Role, true, 200},
+		{"tone", p.Tone, false, 100},
+		{"task", p.Task, false, 300},
+		{"opening", p.Opening, false, 300},
+	}
+	for _, f := range fields {
+		if f.required && f.value == "" {
+			return fmt.Errorf("%s is required", f.name)
+		}
+		if utf8.RuneCountInString(f.value) > f.max {
+			return fmt.Errorf("%s is longer than %d characters", f.name, f.max)
+		}
+		if strings.Contains(f.value, "<<<") || strings.Contains(f.value, ">>>") || len(detectInjection(f.value)) > 0 {
+			return fmt.Errorf("%s looks like instructions to the model", f.name)
+		}
+	}
+	if p.Temperature < 0 || p.Temperature > 1.5 {
+		return errors.New("temperature must be between 0 and 1.5")
+	}
+	return nil
+}
+
+// /api/companion/personas: GET lists the catalog (in ?lang=) and the
+// caller's own personas, POST creates a private persona.
+func handlePersonas(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(userIDKey).(int)
+
+	switch r.Method {
+	case "GET":
+		lang, ok := requireLanguage(w, r.URL.Query().Get("lang"))
+		if !ok {
+			return
+		}
+		res := []*Persona{}
+		for i := range personaCatalog {
+			res = append(res, personaCatalog[i].resolve(lang))
+		}
+
+		rows, err := db.Query(`SELECT id, name, role, tone, task, opening, temperature FROM personas WHERE user_id = ? ORDER BY id`, uid)
+		if err != nil {
+			httpError(w, "DB Query Error", 500)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			p := Persona{Custom: true}
+			if err := rows.Scan(&id, &p.Name, &p.Role, &p.Tone, &p.Task, &p.Opening, &p.Temperature); err != nil {
+				continue
+			}
+			p.ID = customPersonaPrefix + strconv.FormatInt(id, 10)
+			res = append(res, &p)
+		}
+		jsonResponse(w, res)
+
+	case "POST":
+		var req struct {
+			PersonaText
+			Temperature *float32 `json:"temperature"`
+		}
+		if json.NewDecoder(r.Body).Decode(&req) != nil {
+			httpError(w, "Invalid JSON", 400)
+			return
+		}
+		p := Persona{PersonaText: req.PersonaText, Temperature: 0.7, Custom: true}
+		if req.Temperature != nil {
+			p.Temperature = *req.Temperature
+		}
+		if err := p.validate(); err != nil {
+			httpError(w, err.Error(), 400)
+			return
+		}
+
+		var count int
+		db.QueryRow(`SELECT COUNT(*) FROM personas WHERE user_id = ?`, uid).Scan(&count)
+		if count >= maxCustomPersonas {
+			httpError(w, fmt.Sprintf("Не больше %d своих персонажей", maxCustomPersonas), 400)
+			return
+		}
+
+		res, err := db.Exec(`INSERT INTO personas (user_id, name, role, tone, task, opening, temperature) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			uid, p.Name, p.Role, p.Tone, p.Task, p.Opening, p.Temperature)
+		if err != nil {
+			httpError(w, "Failed to create persona", 500)
+			return
+		}
+		id, _ := res.LastInsertId()
+		p.ID = customPersonaPrefix + strconv.FormatInt(id, 10)
+		jsonResponse(w, p)
+
+	default:
+		httpError(w, "Method not allowed", 405)
+	}
+}
+
+// /api/companion/personas/{id}: GET returns a persona, DELETE removes one of
+// the caller's own. Sessions using a deleted persona continue as the mentor.
+func handlePersona(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(userIDKey).(int)
+
+	lang, ok := requireLanguage(w, r.URL.Query().Get("lang"))
+	if !ok {
+		return
+	}
+	p, err := findPersona(uid, r.PathValue("id"), lang)
+	if err != nil {
+		httpError(w, "Persona not found", 404)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		jsonResponse(w, p)
+
+	case "DELETE":
+		if !p.Custom {
+			httpError(w, "Built-in personas cannot be deleted", 403)
+			return
+		}
+		rowID, _ := strconv.ParseInt(strings.TrimPrefix(p.ID, customPersonaPrefix), 10, 64)
+		if _, err := db.Exec(`DELETE FROM personas WHERE id = ? AND user_id = ?`, rowID, uid); err != nil {
+			httpError(w, "Failed to delete persona", 500)
+			return
+		}
+		jsonResponse(w, map[string]string{"msg": "Persona deleted"})
+
+	default:
+		httpError(w, "Method not allowed", 405)
+	}
+}
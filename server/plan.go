@@ -0,0 +1,440 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	planHistoryDays  = 30 // speeches older than this don't shape the plan
+	planHistoryLimit = 20
+	planFocusAreas   = 2  // weakest metrics the week concentrates on
+	planTargetStep   = 10 // an item asks for this much above the average
+	planDefaultScore = 60 // assumed average when there is no history yet
+)
+
+// planRefreshInterval is how often the scheduler creates plans for the new
+// week. PLAN_REFRESH_INTERVAL overrides it.
+var planRefreshInterval = time.Hour
+
+// planMetrics are the AnalysisMetrics a plan can focus on, in the order
+// ties are broken.
+var planMetrics = []string{"structure", "conciseness", "confidence", "vocabulary", "empathy"}
+
+type exerciseText struct {
+	Title       string
+	Description string
+}
+
+// planExercises are the exercises for each metric; a focus area gets all of
+// them, in order. Texts exist for every registered language (checked in
+// initPlans).
+var planExercises = map[string][]map[string]exerciseText{
+	"structure": {
+		{
+			"ru": {"Три части", "Выступите по схеме «вступление — три пункта — вывод». Назовите план в первой фразе."},
+			"en": {"Three parts", "Speak as intro, three points, conclusion. Announce the plan in your first sentence."},
+		},
+		{
+			"ru": {"Проблема — решение", "Опишите проблему, её последствия и ваше решение, по минуте на каждую часть."},
+			"en": {"Problem and solution", "Describe a problem, its consequences and your solution, about a minute each."},
+		},
+		{
+			"ru": {"Связки", "Свяжите каждый переход фразой: «во-первых», «поэтому», «в итоге»."},
+			"en": {"Signposts", "Join every transition with a signpost: \"first\", \"therefore\", \"to sum up\"."},
+		},
+	},
+	"conciseness": {
+		{
+			"ru": {"Одна минута", "Уложите мысль ровно в 60 секунд. Всё, что не помещается, — лишнее."},
+			"en": {"One minute", "Fit the idea into exactly 60 seconds. Whatever doesn't fit is padding."},
+		},
+		{
+			"ru": {"Главное сначала", "Начните с вывода, затем дайте не больше двух аргументов."},
+			"en": {"Bottom line first", "Open with your conclusion, then give at most two arguments."},
+		},
+		{
+			"ru": {"Без повторов", "Не повторяйте ни одной мысли дважды, даже другими словами."},
+			"en": {"No repeats", "Do not say any point twice, not even in other words."},
+		},
+	},
+	"confidence": {
+		{
+			"ru": {"Без оговорок", "Говорите без «наверное», «может быть» и «мне кажется»."},
+			"en": {"No hedging", "Speak without \"maybe\", \"I think\" or \"sort of\"."},
+		},
+		{
+			"ru": {"Паузы вместо «эм»", "Когда нужна секунда подумать, молчите, а не заполняйте паузу звуками."},
+			"en": {"Pause, don't um", "When you need a second to think, stay silent instead of filling the gap."},
+		},
+		{
+			"ru": {"Твёрдая позиция", "Займите однозначную позицию по теме и защитите её."},
+			"en": {"Take a stand", "Take a clear position on the topic and defend it."},
+		},
+	},
+	"vocabulary": {
+		{
+			"ru": {"Точные слова", "Замените общие слова («хороший», «интересный») конкретными."},
+			"en": {"Precise words", "Replace vague words (\"good\", \"interesting\") with specific ones."},
+		},
+		{
+			"ru": {"Образ", "Используйте хотя бы одну метафору или сравнение."},
+			"en": {"Paint a picture", "Use at least one metaphor or comparison."},
+		},
+		{
+			"ru": {"Без любимых слов", "Не используйте одно и то же прилагательное дважды."},
+			"en": {"No favorite words", "Do not use the same adjective twice."},
+		},
+	},
+	"empathy": {
+		{
+			"ru": {"Для слушателя", "Объясните тему человеку, который о ней ничего не знает."},
+			"en": {"For the listener", "Explain the topic to someone who knows nothing about it."},
+		},
+		{
+			"ru": {"История", "Начните с короткой личной истории, связанной с темой."},
+			"en": {"A story", "Open with a short personal story related to the topic."},
+		},
+		{
+			"ru": {"Вопрос залу", "Задайте слушателям вопрос и ответьте на их возможные возражения."},
+			"en": {"Ask the room", "Ask the audience a question and answer the objections they might have."},
+		},
+	},
+}
+
+type PlanFocus struct {
+	Metric   string  `json:"metric"`
+	Average  float64 `json:"average"`  // over the speeches considered, 0-100
+	Speeches int     `json:"speeches"` // how many had this metric
+}
+
+type PlanItem struct {
+	ID          int64      `json:"id"`
+	Metric      string     `json:"metric"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	TopicID     *int64     `json:"topicId,omitempty"` // send it with the speech to complete the item
+	Topic       string     `json:"topic,omitempty"`
+	Target      int        `json:"target"` // score on Metric that completes the item
+	Done        bool       `json:"done"`
+	SpeechID    *int64     `json:"speechId,omitempty"`
+	DoneAt      *time.Time `json:"doneAt,omitempty"`
+}
+
+type TrainingPlan struct {
+	ID        int64       `json:"id"`
+	WeekStart string      `json:"weekStart"` // Monday, UTC
+	WeekEnd   string      `json:"weekEnd"`
+	Focus     []PlanFocus `json:"focus"`
+	Items     []PlanItem  `json:"items"`
+	Done      int         `json:"done"`
+	Total     int         `json:"total"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+func initPlans() {
+	for metric, list := range planExercises {
+		for i, ex := range list {
+			for _, code := range languageCodes() {
+				if t := ex[code]; t.Title == "" || t.Description == "" {
+					log.Fatal("[!] Plan exercise ", metric, "/", i, " has no text for language ", code)
+				}
+			}
+		}
+	}
+	if v := os.Getenv("PLAN_REFRESH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatal("[!] Invalid PLAN_REFRESH_INTERVAL: ", v)
+		}
+		planRefreshInterval = d
+	}
+
+	go func() {
+		refreshPlans()
+		for range time.Tick(planRefreshInterval) {
+			refreshPlans()
+		}
+	}()
+	fmt.Printf("[+] Training plans: refreshed every %s\n", planRefreshInterval)
+}
+
+// weekStart is the Monday of t's week in UTC, as a date.
+func weekStart(t time.Time) string {
+	t = t.UTC()
+	offset := (int(t.Weekday()) + 6) % 7
+	return t.AddDate(0, 0, -offset).Format("2006-01-02")
+}
+
+// refreshPlans creates this week's plan for everyone who practiced within
+// planHistoryDays and does not have one yet.
+func refreshPlans() {
+	week := weekStart(time.Now())
+	rows, err := db.Query(`
+		SELECT DISTINCT s.user_id FROM speeches s
+		WHERE s.created_at >= datetime('now', ?)
+		AND NOT EXISTS (SELECT 1 FROM training_plans p WHERE p.user_id = s.user_id AND p.week_start = ?)`,
+		fmt.Sprintf("-%d days", planHistoryDays), week)
+	if err != nil {
+		log.Println("[!] Plan Refresh Error:", err)
+		return
+	}
+	var users []int
+	for rows.Next() {
+		var uid int
+		if rows.Scan(&uid) == nil {
+			users = append(users, uid)
+		}
+	}
+	rows.Close()
+
+	for _, uid := range users {
+		if err := createPlan(uid, week); err != nil {
+			log.Println("[!] Plan Create Error:", err)
+		}
+	}
+	if len(users) > 0 {
+		fmt.Printf("[*] Training plans created for week %s: %d\n", week, len(users))
+	}
+}
+
+// weakestMetrics averages the metrics of the user's recent speeches and
+// returns them weakest first. Metrics never scored count as planDefaultScore.
+func weakestMetrics(uid int) ([]PlanFocus, error) {
+	rows, err := db.Query(`
+		SELECT metrics FROM speeches
+		WHERE user_id = ? AND COALESCE(kind, 'speech') = 'speech' AND created_at >= datetime('now', ?)
+		ORDER BY created_at DESC LIMIT ?`,
+		uid, fmt.Sprintf("-%d days", planHistoryDays), planHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sums := map[string]float64{}
+	counts := map[string]int{}
+	for rows.Next() {
+		var metStr sql.NullString
+		if rows.Scan(&metStr) != nil {
+			continue
+		}
+		var m map[string]float64
+		if json.Unmarshal([]byte(metStr.String), &m) != nil {
+			continue
+		}
+		for _, name := range planMetrics {
+			if v, ok := m[name]; ok {
+				sums[name] += v
+				counts[name]++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	focus := make([]PlanFocus, 0, len(planMetrics))
+	for _, name := range planMetrics {
+		f := PlanFocus{Metric: name, Average: planDefaultScore, Speeches: counts[name]}
+		if f.Speeches > 0 {
+			f.Average = math.Round(sums[name]/float64(f.Speeches)*10) / 10
+		}
+		focus = append(focus, f)
+	}
+	sort.SliceStable(focus, func(i, j int) bool { return focus[i].Average < focus[j].Average })
+	return focus, nil
+}
+
+// createPlan builds the week's plan: every exercise of the weakest metrics,
+// each with a topic and a target above the current average. A plan that
+// already exists for the week is left alone.
+func createPlan(uid int, week string) error {
+	focus, err := weakestMetrics(uid)
+	if err != nil {
+		return err
+	}
+	focus = focus[:planFocusAreas]
+
+	var topics []int64
+	rows, err := db.Query(`SELECT id FROM topics ORDER BY RANDOM()`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			topics = append(topics, id)
+		}
+	}
+	rows.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	focusBytes, _ := json.Marshal(focus)
+	res, err := tx.Exec(`INSERT OR IGNORE INTO training_plans (user_id, week_start, focus) VALUES (?, ?, ?)`, uid, week, string(focusBytes))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	planID, _ := res.LastInsertId()
+
+	position := 0
+	for _, f := range focus {
+		target := min(100, int(math.Round(f.Average))+planTargetStep)
+		for i := range planExercises[f.Metric] {
+			var topicID sql.NullInt64
+			if len(topics) > 0 {
+				topicID = sql.NullInt64{Int64: topics[position%len(topics)], Valid: true}
+			}
+			_, err := tx.Exec(`INSERT INTO training_plan_items (plan_id, position, metric, exercise, topic_id, target) VALUES (?, ?, ?, ?, ?, ?)`,
+				planID, position, f.Metric, i, topicID, target)
+			if err != nil {
+				return err
+			}
+			position++
+		}
+	}
+	return tx.Commit()
+}
+
+// loadPlan reads the user's plan for a week with texts in lang.
+func loadPlan(uid int, week string, lang *Language) (*TrainingPlan, error) {
+	p := TrainingPlan{WeekStart: week, Items: []PlanItem{}}
+	var focusStr string
+	err := db.QueryRow(`SELECT id, focus, created_at FROM training_plans WHERE user_id = ? AND week_start = ?`, uid, week).
+		Scan(&p.ID, &focusStr, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(focusStr), &p.Focus)
+	if start, err := time.Parse("2006-01-02", week); err == nil {
+		p.WeekEnd = start.AddDate(0, 0, 6).Format("2006-01-02")
+	}
+
+	rows, err := db.Query(`
+		SELECT i.id, i.metric, i.exercise, i.topic_id, i.target, i.speech_id, i.done_at,
+			COALESCE(t.text, te.text, '')
+		FROM training_plan_items i
+		LEFT JOIN topic_translations t ON t.topic_id = i.topic_id AND t.language = ?
+		LEFT JOIN topic_translations te ON te.topic_id = i.topic_id AND te.language = 'en'
+		WHERE i.plan_id = ? ORDER BY i.position`, lang.Code, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var it PlanItem
+		var exercise int
+		var speechID sql.NullInt64
+		var doneAt sql.NullTime
+		if err := rows.Scan(&it.ID, &it.Metric, &exercise, &it.TopicID, &it.Target, &speechID, &doneAt, &it.Topic); err != nil {
+			continue
+		}
+		if list := planExercises[it.Metric]; exercise < len(list) {
+			t := list[exercise][lang.Code]
+			it.Title, it.Description = t.Title, t.Description
+		}
+		if speechID.Valid {
+			it.Done = true
+			it.SpeechID = &speechID.Int64
+			it.DoneAt = &doneAt.Time
+			p.Done++
+		}
+		p.Items = append(p.Items, it)
+	}
+	p.Total = len(p.Items)
+	return &p, rows.Err()
+}
+
+// completePlanItems marks the first open item of each metric the speech
+// reached the target on, and returns the ids of the items it completed.
+// Items tied to a topic only count speeches on that topic.
+func completePlanItems(uid int, speechID, topicID int64, metrics AnalysisMetrics) []int64 {
+	var scores map[string]int
+	b, _ := json.Marshal(metrics)
+	json.Unmarshal(b, &scores)
+
+	rows, err := db.Query(`
+		SELECT i.id, i.metric, i.target FROM training_plan_items i
+		JOIN training_plans p ON p.id = i.plan_id
+		WHERE p.user_id = ? AND p.week_start = ? AND i.speech_id IS NULL
+			AND (i.topic_id IS NULL OR i.topic_id = ?)
+		ORDER BY i.position`, uid, weekStart(time.Now()), topicID)
+	if err != nil {
+		log.Println("[!] Plan Progress Error:", err)
+		return nil
+	}
+	type openItem struct {
+		id     int64
+		metric string
+		target int
+	}
+	var open []openItem
+	for rows.Next() {
+		var it openItem
+		if rows.Scan(&it.id, &it.metric, &it.target) == nil {
+			open = append(open, it)
+		}
+	}
+	rows.Close()
+
+	var done []int64
+	seen := map[string]bool{}
+	for _, it := range open {
+		if seen[it.metric] || scores[it.metric] < it.target {
+			continue
+		}
+		seen[it.metric] = true
+		res, err := db.Exec(`UPDATE training_plan_items SET speech_id = ?, done_at = CURRENT_TIMESTAMP WHERE id = ? AND speech_id IS NULL`, speechID, it.id)
+		if err != nil {
+			log.Println("[!] Plan Progress Error:", err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			done = append(done, it.id)
+		}
+	}
+	return done
+}
+
+// GET /api/plan?lang= returns this week's training plan, creating it if the
+// scheduler has not got to the user yet.
+func handlePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, "Method not allowed", 405)
+		return
+	}
+	uid := r.Context().Value(userIDKey).(int)
+	lang, ok := requireLanguage(w, r.URL.Query().Get("lang"))
+	if !ok {
+		return
+	}
+
+	week := weekStart(time.Now())
+	p, err := loadPlan(uid, week, lang)
+	if err == sql.ErrNoRows {
+		if err = createPlan(uid, week); err == nil {
+			p, err = loadPlan(uid, week, lang)
+		}
+	}
+	if err != nil {
+		log.Println("[!] Plan Read Error:", err)
+		httpError(w, "DB Error", 500)
+		return
+	}
+	jsonResponse(w, p)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+// setupTestDB opens a fresh database in a temporary directory with the
+// offline LLM and one user, and returns the user's id.
+func setupTestDB(t *testing.T) int {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	initDB()
+	t.Cleanup(func() { db.Close() })
+	llm = fakeProvider{}
+	initPrompts()
+
+	res, err := db.Exec(`INSERT INTO users (username, email, password) VALUES ('test', 'test@example.com', '')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return int(id)
+}
+
+// A plan item is completed by a speech given on its topic, with the topic
+// id taken from the plan as the client sees it.
+func TestPlanItemCompletedByTopicSpeech(t *testing.T) {
+	uid := setupTestDB(t)
+	week := weekStart(time.Now())
+	if err := createPlan(uid, week); err != nil {
+		t.Fatal(err)
+	}
+	// Any score completes an item, so the test does not depend on what the
+	// fake model returns.
+	db.Exec(`UPDATE training_plan_items SET target = 0`)
+
+	lang := languages["en"]
+	plan, err := loadPlan(uid, week, lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(plan)
+	var client struct {
+		Items []struct {
+			ID      int64  `json:"id"`
+			Metric  string `json:"metric"`
+			TopicID int64  `json:"topicId"`
+			Done    bool   `json:"done"`
+		} `json:"items"`
+	}
+	json.Unmarshal(b, &client)
+	if len(client.Items) == 0 {
+		t.Fatal("plan has no items")
+	}
+	item := client.Items[0]
+	if item.TopicID == 0 {
+		t.Fatalf("item %d has no topicId in %s", item.ID, b)
+	}
+
+	analyze := func(transcript string, topicID int64) *AnalysisResult {
+		t.Helper()
+		body, _ := json.Marshal(map[string]interface{}{"transcript": transcript, "durationSeconds": 30, "language": "en", "topicId": topicID})
+		var req AnalyzeRequest
+		json.Unmarshal(body, &req)
+		res, err := runAnalysis(context.Background(), uid, req, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// Without the topic nothing is completed: every item has one.
+	if res := analyze("A speech about nothing in particular, given without a topic.", 0); len(res.PlanItemsDone) != 0 {
+		t.Fatalf("speech without a topic completed %v", res.PlanItemsDone)
+	}
+
+	res := analyze("A speech on the topic of the first exercise of the week.", item.TopicID)
+	if !slices.Contains(res.PlanItemsDone, item.ID) {
+		t.Fatalf("PlanItemsDone = %v, want item %d", res.PlanItemsDone, item.ID)
+	}
+	plan, _ = loadPlan(uid, week, lang)
+	for _, it := range plan.Items {
+		if it.ID == item.ID && (!it.Done || it.SpeechID == nil || *it.SpeechID != res.ID) {
+			t.Fatalf("item %+v not completed by speech %d", it, res.ID)
+		}
+	}
+}
+
+// The analysis cache is shared between users: a transcript another user
+// already submitted is still new practice for this one.
+func TestPlanItemCompletedOnCacheHit(t *testing.T) {
+	first := setupTestDB(t)
+	res, _ := db.Exec(`INSERT INTO users (username, email, password) VALUES ('second', 'second@example.com', '')`)
+	id, _ := res.LastInsertId()
+	second := int(id)
+
+	week := weekStart(time.Now())
+	for _, uid := range []int{first, second} {
+		if err := createPlan(uid, week); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Exec(`UPDATE training_plan_items SET topic_id = NULL, target = 0`)
+
+	req := AnalyzeRequest{Transcript: "The same speech, word for word, from two different people.", Duration: 30, Language: "en"}
+	for i, uid := range []int{first, second} {
+		res, err := runAnalysis(context.Background(), uid, req, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Cached != (i == 1) {
+			t.Fatalf("user %d: cached = %v", uid, res.Cached)
+		}
+		if len(res.PlanItemsDone) == 0 {
+			t.Fatalf("user %d completed no plan items", uid)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// Prompts are text/template files named <prompt>.<lang>.tmpl, or
+// <prompt>.tmpl when one file serves every language. The defaults are
+// embedded; files in PROMPTS_DIR override them by name and are reloaded
+// while the server runs.
+//
+//go:embed prompts/*.tmpl
+var embeddedPrompts embed.FS
+
+const promptReloadInterval = 5 * time.Second
+
+// Prompts every registered language must be able to render.
+var requiredPrompts = []string{"analysis", "companion", "summary", "scorecard", "rewrite"}
+
+type promptTemplate struct {
+	tmpl    *template.Template
+	version string // <file>:<content hash>, recorded with the speech
+}
+
+type promptSet map[string]*promptTemplate // by file name
+
+var prompts atomic.Pointer[promptSet]
+
+// promptData is what templates can use.
+type promptData struct {
+	Language *Language
+	Persona  *Persona // companion prompt only
+}
+
+func initPrompts() {
+	dir := os.Getenv("PROMPTS_DIR")
+	set, err := loadPrompts(dir)
+	if err != nil {
+		log.Fatal("[!] Prompt Templates Error: ", err)
+	}
+	prompts.Store(&set)
+
+	if dir == "" {
+		fmt.Printf("[+] Prompts: %d embedded templates\n", len(set))
+		return
+	}
+	fmt.Printf("[+] Prompts: %d templates (overrides from %s)\n", len(set), dir)
+	go watchPrompts(dir)
+}
+
+// loadPrompts parses the embedded templates, applies overrides from dir and
+// checks that every language renders every required prompt.
+func loadPrompts(dir string) (promptSet, error) {
+	files := map[string][]byte{}
+	embedded, _ := fs.Glob(embeddedPrompts, "prompts/*.tmpl")
+	for _, name := range embedded {
+		b, _ := embeddedPrompts.ReadFile(name)
+		files[path.Base(name)] = b
+	}
+	if dir != "" {
+		overrides, err := fs.Glob(os.DirFS(dir), "*.tmpl")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range overrides {
+			b, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			files[name] = b
+		}
+	}
+
+	set := promptSet{}
+	for name, b := range files {
+		t, err := template.New(name).Option("missingkey=error").Parse(string(b))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(b)
+		set[name] = &promptTemplate{tmpl: t, version: strings.TrimSuffix(name, ".tmpl") + ":" + hex.EncodeToString(sum[:4])}
+	}
+
+	for _, code := range languageCodes() {
+		for _, p := range requiredPrompts {
+			for i := range personaCatalog {
+				data := promptData{Language: languages[code], Persona: personaCatalog[i].resolve(languages[code])}
+				if _, _, err := set.render(p, data); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return set, nil
+}
+
+func (s promptSet) render(name string, data promptData) (string, string, error) {
+	t, ok := s[name+"."+data.Language.Code+".tmpl"]
+	if !ok {
+		t, ok = s[name+".tmpl"]
+	}
+	if !ok {
+		return "", "", fmt.Errorf("no %q prompt for language %s", name, data.Language.Code)
+	}
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(buf.String()), t.version, nil
+}
+
+// renderPrompt returns the prompt text and its version. Every language was
+// checked at load time, so an error here means a template failed on data
+// the check did not cover.
+func renderPrompt(name string, data promptData) (string, string, error) {
+	return (*prompts.Load()).render(name, data)
+}
+
+// watchPrompts reloads the templates when a file in dir changes. A set that
+// fails validation is logged and the previous one stays in use.
+func watchPrompts(dir string) {
+	last := promptsFingerprint(dir)
+	for range time.Tick(promptReloadInterval) {
+		fp := promptsFingerprint(dir)
+		if fp == last {
+			continue
+		}
+		last = fp
+
+		set, err := loadPrompts(dir)
+		if err != nil {
+			log.Println("[!] Prompt Reload Error (keeping previous templates):", err)
+			continue
+		}
+		prompts.Store(&set)
+		fmt.Printf("[+] Prompts reloaded from %s\n", dir)
+	}
+}
+
+func promptsFingerprint(dir string) string {
+	entries, _ := os.ReadDir(dir)
+	var parts []string
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && strings.HasSuffix(e.Name(), ".tmpl") {
+			parts = append(parts, fmt.Sprintf("%s/%d/%d", e.Name(), info.Size(), info.ModTime().UnixNano()))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "|")
+}
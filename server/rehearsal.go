@@ -0,0 +1,330 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxScripts        = 50
+	maxScriptTitle    = 120
+	maxScriptWords    = 3000
+	maxSpokenWords    = 2 * maxScriptWords // aligned against the script; the rest are insertions
+	skippedCoverage   = 0.5                // a section with less of its words spoken was skipped
+	adLibMinWords     = 3                  // shorter additions are noise, not ad-libs
+	sectionPreviewLen = 80                 // characters of a section's text in the report
+)
+
+var errScriptNotFound = errors.New("script not found")
+
+// RehearsalResult compares a spoken transcript with the script it was
+// rehearsed from. Everything is computed in Go from a word alignment; the
+// LLM never sees the script.
+type RehearsalResult struct {
+	ScriptID      int64           `json:"scriptId"`
+	ScriptTitle   string          `json:"scriptTitle"`
+	ScriptWords   int             `json:"scriptWords"`
+	SpokenWords   int             `json:"spokenWords"`
+	Matched       int             `json:"matched"`
+	Substitutions int             `json:"substitutions"`
+	Deletions     int             `json:"deletions"`
+	Insertions    int             `json:"insertions"`
+	Coverage      float64         `json:"coverage"` // share of script words spoken, 0-1
+	WER           float64         `json:"wer"`      // word error rate against the script
+	Sections      []ScriptSection `json:"sections"`
+	Skipped       []int           `json:"skipped"` // indexes into Sections
+	AdLibs        []string        `json:"adLibs"`  // spoken passages that are not in the script
+}
+
+// ScriptSection is a paragraph of the script, or a sentence when the script
+// is a single paragraph.
+type ScriptSection struct {
+	Index    int     `json:"index"`
+	Text     string  `json:"text"`
+	Words    int     `json:"words"`
+	Coverage float64 `json:"coverage"`
+	Skipped  bool    `json:"skipped"`
+}
+
+type scriptWord struct {
+	word    string
+	section int
+}
+
+// compareToScript aligns the transcript to the script word by word, ignoring
+// case and punctuation. Substitutions are counted per changed block as the
+// overlap of deleted and inserted words, which is what Levenshtein-based WER
+// would report for the same alignment.
+func compareToScript(s *Script, transcript, lang string) *RehearsalResult {
+	sections, words := splitScript(s.Content)
+	spoken := keyedWords(strings.Fields(transcript))
+
+	ref := make([]string, len(words))
+	for i, w := range words {
+		ref[i] = w.word
+	}
+	// Alignment time grows with the distance between the texts, so a
+	// transcript far longer than any script is compared by its start only.
+	extra := max(len(spoken)-maxSpokenWords, 0)
+	hyp := make([]string, len(spoken)-extra)
+	for i := range hyp {
+		hyp[i] = spoken[i].word
+	}
+
+	res := &RehearsalResult{
+		ScriptID:    s.ID,
+		ScriptTitle: s.Title,
+		ScriptWords: len(ref),
+		SpokenWords: len(spoken),
+		Insertions:  extra,
+		Sections:    []ScriptSection{},
+		Skipped:     []int{},
+		AdLibs:      []string{},
+	}
+	spokenPerSection := make([]int, len(sections))
+	fillers := languageFor(lang).Fillers
+
+	ops := myersDiff(ref, hyp)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == '=' {
+			res.Matched++
+			spokenPerSection[words[ops[i].a].section]++
+			i++
+			continue
+		}
+		var del, ins int
+		var added []string
+		for ; i < len(ops) && ops[i].kind != '='; i++ {
+			if ops[i].kind == '-' {
+				del++
+			} else {
+				ins++
+				added = append(added, spoken[ops[i].b].text)
+			}
+		}
+		sub := min(del, ins)
+		res.Substitutions += sub
+		res.Deletions += del - sub
+		res.Insertions += ins - sub
+
+		if ins-del >= adLibMinWords && !coveredBy(tokenizeWords(strings.Join(added, " ")), fillers) {
+			res.AdLibs = append(res.AdLibs, strings.Join(added, " "))
+		}
+	}
+
+	if res.ScriptWords > 0 {
+		res.Coverage = round3(float64(res.Matched) / float64(res.ScriptWords))
+		res.WER = round3(float64(res.Substitutions+res.Deletions+res.Insertions) / float64(res.ScriptWords))
+	}
+	for i, sec := range sections {
+		sec.Coverage = round3(float64(spokenPerSection[i]) / float64(sec.Words))
+		sec.Skipped = sec.Coverage < skippedCoverage
+		if sec.Skipped {
+			res.Skipped = append(res.Skipped, i)
+		}
+		res.Sections = append(res.Sections, sec)
+	}
+	return res
+}
+
+type keyedWord struct {
+	text, word string
+}
+
+// keyedWords pairs words with their diff keys and drops tokens that are
+// punctuation only ("—", "..."), which would otherwise count as errors.
+func keyedWords(fields []string) []keyedWord {
+	keys := diffKeys(fields)
+	out := make([]keyedWord, 0, len(fields))
+	for i, k := range keys {
+		if k != "" {
+			out = append(out, keyedWord{text: fields[i], word: k})
+		}
+	}
+	return out
+}
+
+// splitScript cuts the script into sections and returns its words tagged
+// with their section. Sections without words are dropped.
+func splitScript(content string) ([]ScriptSection, []scriptWord) {
+	var parts []string
+	for _, p := range strings.Split(content, "\n") {
+		if strings.TrimSpace(p) != "" {
+			parts = append(parts, strings.TrimSpace(p))
+		}
+	}
+	if len(parts) == 1 {
+		parts = splitSentences(parts[0])
+	}
+
+	var sections []ScriptSection
+	var words []scriptWord
+	for _, p := range parts {
+		kw := keyedWords(strings.Fields(p))
+		if len(kw) == 0 {
+			continue
+		}
+		for _, w := range kw {
+			words = append(words, scriptWord{word: w.word, section: len(sections)})
+		}
+		sections = append(sections, ScriptSection{Index: len(sections), Text: preview(p, sectionPreviewLen), Words: len(kw)})
+	}
+	return sections, words
+}
+
+// splitSentences splits after terminal punctuation that ends a word.
+func splitSentences(text string) []string {
+	var out []string
+	var cur []string
+	for _, w := range strings.Fields(text) {
+		cur = append(cur, w)
+		if last, _ := utf8.DecodeLastRuneInString(w); strings.ContainsRune(".!?…", last) {
+			out = append(out, strings.Join(cur, " "))
+			cur = nil
+		}
+	}
+	if len(cur) > 0 {
+		out = append(out, strings.Join(cur, " "))
+	}
+	return out
+}
+
+// preview shortens text to at most n characters plus an ellipsis.
+func preview(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	r := []rune(text)
+	return strings.TrimSpace(string(r[:n])) + "…"
+}
+
+func loadScript(uid int, id int64) (*Script, error) {
+	var s Script
+	err := db.QueryRow(`SELECT id, title, content, language, created_at FROM scripts WHERE id = ? AND user_id = ?`, id, uid).
+		Scan(&s.ID, &s.Title, &s.Content, &s.Language, &s.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errScriptNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Words = len(keyedWords(strings.Fields(s.Content)))
+	return &s, nil
+}
+
+// /api/scripts: GET lists the caller's scripts without their text, POST
+// saves a new one.
+func handleScripts(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(userIDKey).(int)
+
+	switch r.Method {
+	case "GET":
+		rows, err := db.Query(`SELECT id, title, content, language, created_at FROM scripts WHERE user_id = ? ORDER BY created_at DESC, id DESC`, uid)
+		if err != nil {
+			httpError(w, "DB Query Error", 500)
+			return
+		}
+		defer rows.Close()
+
+		res := []Script{}
+		for rows.Next() {
+			var s Script
+			if err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Language, &s.CreatedAt); err != nil {
+				continue
+			}
+			s.Words = len(keyedWords(strings.Fields(s.Content)))
+			s.Content = ""
+			res = append(res, s)
+		}
+		jsonResponse(w, res)
+
+	case "POST":
+		var s Script
+		if json.NewDecoder(r.Body).Decode(&s) != nil {
+			httpError(w, "Invalid JSON", 400)
+			return
+		}
+		lang, ok := requireLanguage(w, s.Language)
+		if !ok {
+			return
+		}
+		s.Language = lang.Code
+		s.Title = strings.TrimSpace(s.Title)
+		s.Content = strings.TrimSpace(s.Content)
+		s.Words = len(keyedWords(strings.Fields(s.Content)))
+
+		switch {
+		case s.Title == "" || utf8.RuneCountInString(s.Title) > maxScriptTitle:
+			httpError(w, fmt.Sprintf("Title is required (at most %d characters)", maxScriptTitle), 400)
+			return
+		case s.Words == 0:
+			httpError(w, "Нет текста", 400)
+			return
+		case s.Words > maxScriptWords:
+			httpError(w, fmt.Sprintf("Script is longer than %d words", maxScriptWords), 400)
+			return
+		}
+
+		var count int
+		db.QueryRow(`SELECT COUNT(*) FROM scripts WHERE user_id = ?`, uid).Scan(&count)
+		if count >= maxScripts {
+			httpError(w, fmt.Sprintf("Не больше %d сценариев", maxScripts), 400)
+			return
+		}
+
+		res, err := db.Exec(`INSERT INTO scripts (user_id, title, content, language) VALUES (?, ?, ?, ?)`,
+			uid, s.Title, s.Content, s.Language)
+		if err != nil {
+			httpError(w, "Failed to create script", 500)
+			return
+		}
+		id, _ := res.LastInsertId()
+		saved, err := loadScript(uid, id)
+		if err != nil {
+			httpError(w, "DB Error", 500)
+			return
+		}
+		jsonResponse(w, saved)
+
+	default:
+		httpError(w, "Method not allowed", 405)
+	}
+}
+
+// /api/scripts/{id}: GET returns the script, DELETE removes it. Rehearsals
+// already graded against it keep their results.
+func handleScript(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(userIDKey).(int)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "Invalid script id", 400)
+		return
+	}
+	s, err := loadScript(uid, id)
+	if err != nil {
+		httpError(w, "Script not found", 404)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		jsonResponse(w, s)
+
+	case "DELETE":
+		if _, err := db.Exec(`DELETE FROM scripts WHERE id = ? AND user_id = ?`, s.ID, uid); err != nil {
+			httpError(w, "Failed to delete script", 500)
+			return
+		}
+		jsonResponse(w, map[string]string{"msg": "Script deleted"})
+
+	default:
+		httpError(w, "Method not allowed", 405)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompareToScriptExact(t *testing.T) {
+	s := &Script{ID: 1, Content: "Good morning everyone.\nToday we talk about growth.\nThank you."}
+	res := compareToScript(s, "Good morning, everyone! Today we talk about growth. Thank you.", "en")
+	if res.Coverage != 1 || res.WER != 0 || res.Matched != 10 {
+		t.Errorf("coverage %v, wer %v, matched %d; want 1, 0, 10", res.Coverage, res.WER, res.Matched)
+	}
+	if len(res.Sections) != 3 || len(res.Skipped) != 0 || len(res.AdLibs) != 0 {
+		t.Errorf("sections %d, skipped %v, ad-libs %v", len(res.Sections), res.Skipped, res.AdLibs)
+	}
+}
+
+func TestCompareToScriptErrors(t *testing.T) {
+	// One substitution (ten → five), one deletion (really), one insertion (very).
+	s := &Script{Content: "We really grew ten percent this year."}
+	res := compareToScript(s, "We grew five percent this very year.", "en")
+	if res.Substitutions != 1 || res.Deletions != 1 || res.Insertions != 1 {
+		t.Errorf("S/D/I = %d/%d/%d, want 1/1/1", res.Substitutions, res.Deletions, res.Insertions)
+	}
+	if want := round3(3.0 / 7); res.WER != want {
+		t.Errorf("wer %v, want %v", res.WER, want)
+	}
+	if want := round3(5.0 / 7); res.Coverage != want {
+		t.Errorf("coverage %v, want %v", res.Coverage, want)
+	}
+}
+
+func TestCompareToScriptSkippedSections(t *testing.T) {
+	s := &Script{Content: strings.Join([]string{
+		"First we look at the market and our customers.",
+		"Then we discuss the budget for the next quarter in detail.",
+		"Finally we agree on the plan.",
+	}, "\n")}
+	res := compareToScript(s, "First we look at the market and our customers. Finally we agree on the plan.", "en")
+	if len(res.Skipped) != 1 || res.Skipped[0] != 1 {
+		t.Fatalf("skipped %v, want [1]", res.Skipped)
+	}
+	if !res.Sections[1].Skipped || res.Sections[1].Coverage >= skippedCoverage {
+		t.Errorf("section 1: %+v", res.Sections[1])
+	}
+	if res.Sections[0].Coverage != 1 || res.Sections[2].Coverage != 1 {
+		t.Errorf("sections 0 and 2 coverage %v, %v", res.Sections[0].Coverage, res.Sections[2].Coverage)
+	}
+}
+
+func TestCompareToScriptAdLibs(t *testing.T) {
+	s := &Script{Content: "We grew this year. Thank you."}
+	res := compareToScript(s, "We grew this year, and honestly the whole team deserves the credit. Um, uh, um, thank you.", "en")
+	if len(res.AdLibs) != 1 || !strings.HasPrefix(res.AdLibs[0], "and honestly") {
+		t.Errorf("ad-libs %q, want one starting with \"and honestly\"", res.AdLibs)
+	}
+}
+
+func TestCompareToScriptLongTranscript(t *testing.T) {
+	s := &Script{Content: "Short script here."}
+	spoken := strings.Repeat("word ", maxSpokenWords+500)
+	res := compareToScript(s, spoken, "en")
+	if res.SpokenWords != maxSpokenWords+500 {
+		t.Errorf("spoken words %d", res.SpokenWords)
+	}
+	// Every spoken word is an error: 3 substitutions, the rest insertions,
+	// including the words past maxSpokenWords that were never aligned.
+	if res.Substitutions != 3 || res.Insertions != maxSpokenWords+500-3 {
+		t.Errorf("S/I = %d/%d, want 3/%d", res.Substitutions, res.Insertions, maxSpokenWords+500-3)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// A rewrite more than this many times longer than the original is the model
+// inventing content, not improving the speech.
+const rewriteMaxGrowth = 2
+
+// Longer speeches are not rewritten: the word diff's time grows with the
+// length of both texts, and the rewrite may be up to rewriteMaxGrowth longer.
+const maxRewriteWords = 4000
+
+var rewriteSchema = &genai.Schema{
+	Type:       genai.TypeObject,
+	Properties: map[string]*genai.Schema{"rewritten": {Type: genai.TypeString}},
+	Required:   []string{"rewritten"},
+}
+
+// POST /api/speeches/{id}/rewrite returns an improved version of the speech
+// and a word-level diff against the original. The rewrite is kept per
+// prompt version; ?force=1 asks the model again.
+func handleSpeechRewrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		httpError(w, "Method not allowed", 405)
+		return
+	}
+	uid := r.Context().Value(userIDKey).(int)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "Invalid speech id", 400)
+		return
+	}
+	var transcript string
+	var langCode, kind sql.NullString
+	err = db.QueryRow(`SELECT transcript, language, kind FROM speeches WHERE id = ? AND user_id = ?`, id, uid).
+		Scan(&transcript, &langCode, &kind)
+	if err != nil {
+		httpError(w, "Speech not found", 404)
+		return
+	}
+	if kind.String == "companion" {
+		httpError(w, "Only speeches can be rewritten", 400)
+		return
+	}
+	if len(strings.Fields(transcript)) > maxRewriteWords {
+		httpError(w, fmt.Sprintf("Speech is longer than %d words", maxRewriteWords), 400)
+		return
+	}
+	// Speeches saved before languages were recorded use the default.
+	lang := languageFor(langCode.String)
+
+	system, promptVersion, err := renderPrompt("rewrite", promptData{Language: lang})
+	if err != nil {
+		log.Println("[!] Prompt Error:", err)
+		httpError(w, "AI Error", 500)
+		return
+	}
+
+	var rewritten string
+	cached := false
+	if q := r.URL.Query().Get("force"); q != "1" && q != "true" {
+		cached = db.QueryRow(`SELECT rewritten FROM speech_rewrites WHERE speech_id = ? AND prompt_version = ?`, id, promptVersion).
+			Scan(&rewritten) == nil
+	}
+	if !cached {
+		if !checkQuota(w, uid) {
+			return
+		}
+		rewritten, err = rewriteSpeech(withUsage(r.Context(), uid, "rewrite"), system, transcript)
+		if err != nil {
+			httpError(w, analysisErrorMessage(err), 500)
+			return
+		}
+		_, err = db.Exec(`INSERT OR REPLACE INTO speech_rewrites (speech_id, rewritten, prompt_version) VALUES (?, ?, ?)`,
+			id, rewritten, promptVersion)
+		if err != nil {
+			log.Println("[!] Rewrite Save Error:", err)
+		}
+	}
+
+	res := RewriteResult{
+		SpeechID:      id,
+		Original:      transcript,
+		Rewritten:     rewritten,
+		Diff:          diffTranscripts(transcript, rewritten, lang.Code),
+		Changes:       map[string]int{},
+		Before:        computeLocalMetrics(transcript, lang.Code),
+		After:         computeLocalMetrics(rewritten, lang.Code),
+		PromptVersion: promptVersion,
+		Cached:        cached,
+	}
+	for _, seg := range res.Diff {
+		if seg.Reason != "" {
+			res.Changes[seg.Reason]++
+		}
+	}
+	jsonResponse(w, res)
+}
+
+func rewriteSpeech(ctx context.Context, system, transcript string) (string, error) {
+	req := LLMRequest{
+		System: system + "\n\n" + dataInstruction,
+		Prompt: wrapUserData(transcript),
+		Config: analysisGeneration,
+		JSON:   true,
+		Schema: rewriteSchema,
+	}
+	limit := rewriteMaxGrowth*len(strings.Fields(transcript)) + 20
+
+	var rewritten string
+	err := generateJSON(ctx, req, func(text string) error {
+		raw := extractJSONObject(text)
+		if raw == "" {
+			return errors.New("no JSON object found")
+		}
+		var out struct {
+			Rewritten string `json:"rewritten"`
+		}
+		if err := json.Unmarshal([]byte(raw), &out); err != nil {
+			return fmt.Errorf("malformed JSON: %v", err)
+		}
+		rewritten = strings.TrimSpace(out.Rewritten)
+		if rewritten == "" {
+			return errors.New("rewritten is empty")
+		}
+		if n := len(strings.Fields(rewritten)); n > limit {
+			return fmt.Errorf("rewritten has %d words, at most %d allowed", n, limit)
+		}
+		return nil
+	})
+	return rewritten, err
+}
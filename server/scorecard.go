@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// errSessionFinished is returned when a session already has a scorecard.
+var errSessionFinished = errors.New("session already finished")
+
+// POST /api/companion/sessions/{id}/finish grades the user's side of the
+// conversation against the persona's rubric. The scorecard is stored as a
+// speech (kind "companion") and earns XP like one.
+func handleCompanionFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		httpError(w, "Method not allowed", 405)
+		return
+	}
+	uid := r.Context().Value(userIDKey).(int)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "Invalid session id", 400)
+		return
+	}
+	s, err := loadCompanionSession(uid, id)
+	if err != nil {
+		httpError(w, "Session not found", 404)
+		return
+	}
+	if s.FinishedAt != nil {
+		httpError(w, "Session already finished", 409)
+		return
+	}
+	if !checkQuota(w, uid) {
+		return
+	}
+
+	lang := languageFor(s.Language)
+	persona, err := findPersona(uid, s.Mode, lang)
+	if err != nil {
+		persona, _ = findPersona(uid, defaultPersona, lang)
+	}
+
+	conversation, userText, err := sessionTranscript(s.ID)
+	if err != nil {
+		httpError(w, "DB Query Error", 500)
+		return
+	}
+	if strings.TrimSpace(userText) == "" {
+		httpError(w, "Нет реплик пользователя", 400)
+		return
+	}
+
+	system, promptVersion, err := renderPrompt("scorecard", promptData{Language: lang, Persona: persona})
+	if err != nil {
+		log.Println("[!] Prompt Error:", err)
+		httpError(w, "AI Error", 500)
+		return
+	}
+	card, err := evaluateScorecard(withUsage(r.Context(), uid, "companion_scorecard"), system, conversation, persona.Rubric)
+	if err != nil {
+		httpError(w, analysisErrorMessage(err), 500)
+		return
+	}
+	if hits := detectInjection(userText); len(hits) > 0 {
+		log.Printf("[!] Possible prompt injection in companion session %d: %q", s.ID, hits)
+		card.Suspicious = true
+		card.OverallScore = min(card.OverallScore, injectionScoreCap)
+		for i := range card.Criteria {
+			card.Criteria[i].Score = min(card.Criteria[i].Score, injectionScoreCap)
+		}
+	}
+
+	card.SessionID = s.ID
+	card.PersonaID = persona.ID
+	card.PromptVersion = promptVersion
+	card.LocalMetrics = computeLocalMetrics(userText, lang.Code)
+
+	if err := saveScorecard(uid, lang.Code, conversation, card); err != nil {
+		if errors.Is(err, errSessionFinished) {
+			httpError(w, "Session already finished", 409)
+			return
+		}
+		log.Println("[!] DB Save Error:", err)
+		httpError(w, "DB Error", 500)
+		return
+	}
+
+	card.Gamification = processGamification(uid, card.OverallScore, 0)
+	jsonResponse(w, card)
+}
+
+// sessionTranscript renders the session for grading: the whole conversation
+// with speaker labels, and the user's turns alone for local metrics.
+func sessionTranscript(sessionID int64) (string, string, error) {
+	rows, err := db.Query(`SELECT role, content FROM companion_messages WHERE session_id = ? ORDER BY id`, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	defer rows.Close()
+
+	var conversation, user strings.Builder
+	for rows.Next() {
+		var role, content string
+		if rows.Scan(&role, &content) != nil {
+			continue
+		}
+		speaker := "PARTNER"
+		if role == "user" {
+			speaker = "USER"
+			user.WriteString(content + "\n")
+		}
+		fmt.Fprintf(&conversation, "%s: %s\n", speaker, content)
+	}
+	return conversation.String(), user.String(), rows.Err()
+}
+
+func scorecardSchema(rubric []RubricCriterion) *genai.Schema {
+	score := &genai.Schema{Type: genai.TypeInteger, Description: "0-100"}
+	criteria := &genai.Schema{Type: genai.TypeObject, Properties: map[string]*genai.Schema{}}
+	for _, c := range rubric {
+		criteria.Properties[c.ID] = score
+		criteria.Required = append(criteria.Required, c.ID)
+	}
+	return &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"overallScore": score,
+			"criteria":     criteria,
+			"feedback":     {Type: genai.TypeString},
+			"tip":          {Type: genai.TypeString},
+		},
+		Required: []string{"overallScore", "criteria", "feedback", "tip"},
+	}
+}
+
+// evaluateScorecard is evaluateSpeech for a whole conversation: the same
+// retry-until-valid loop, with the rubric deciding which criteria must be
+// present.
+func evaluateScorecard(ctx context.Context, system, conversation string, rubric []RubricCriterion) (*Scorecard, error) {
+	req := LLMRequest{
+		System: system + "\n\n" + dataInstruction,
+		Prompt: wrapUserData(conversation),
+		Config: analysisGeneration,
+		JSON:   true,
+		Schema: scorecardSchema(rubric),
+	}
+
+	var card *Scorecard
+	err := generateJSON(ctx, req, func(text string) (err error) {
+		card, err = parseScorecard(text, rubric)
+		return err
+	})
+	return card, err
+}
+
+func parseScorecard(text string, rubric []RubricCriterion) (*Scorecard, error) {
+	raw := extractJSONObject(text)
+	if raw == "" {
+		return nil, errors.New("no JSON object found")
+	}
+
+	var in struct {
+		OverallScore *float64           `json:"overallScore"`
+		Criteria     map[string]float64 `json:"criteria"`
+		Feedback     string             `json:"feedback"`
+		Tip          string             `json:"tip"`
+	}
+	if err := json.Unmarshal([]byte(raw), &in); err != nil {
+		return nil, fmt.Errorf("malformed JSON: %v", err)
+	}
+
+	var errs []string
+	inRange := func(name string, v float64) int {
+		if v < 0 || v > 100 {
+			errs = append(errs, fmt.Sprintf("%s=%v is out of range 0-100", name, v))
+		}
+		return int(math.Round(v))
+	}
+
+	card := &Scorecard{
+		Feedback: strings.TrimSpace(in.Feedback),
+		Tip:      strings.TrimSpace(in.Tip),
+		Criteria: []ScorecardCriterion{},
+	}
+	if in.OverallScore == nil {
+		errs = append(errs, "overallScore is missing")
+	} else {
+		card.OverallScore = inRange("overallScore", *in.OverallScore)
+	}
+	for _, c := range rubric {
+		v, ok := in.Criteria[c.ID]
+		if !ok {
+			errs = append(errs, "criteria."+c.ID+" is missing")
+			continue
+		}
+		card.Criteria = append(card.Criteria, ScorecardCriterion{
+			ID:          c.ID,
+			Description: c.Description,
+			Score:       inRange("criteria."+c.ID, v),
+		})
+	}
+	if card.Feedback == "" {
+		errs = append(errs, "feedback is empty")
+	}
+	if card.Tip == "" {
+		errs = append(errs, "tip is empty")
+	}
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	return card, nil
+}
+
+// saveScorecard stores the scorecard as a speech and links it to the
+// session in one transaction, so a session is graded at most once. The
+// session stays finished if the scorecard is deleted later.
+func saveScorecard(uid int, lang, conversation string, card *Scorecard) error {
+	criteria := map[string]int{}
+	for _, c := range card.Criteria {
+		criteria[c.ID] = c.Score
+	}
+	fillers := make([]string, 0, len(card.LocalMetrics.Fillers))
+	for f := range card.LocalMetrics.Fillers {
+		fillers = append(fillers, f)
+	}
+	sort.Strings(fillers)
+
+	fwBytes, _ := json.Marshal(fillers)
+	metricsBytes, _ := json.Marshal(criteria)
+	localBytes, _ := json.Marshal(card.LocalMetrics)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO speeches (user_id, transcript, clarity_score, pace_wpm, filler_words, feedback, tip, metrics, local_metrics, prompt_version, kind, session_id, language)
+		SELECT ?, ?, ?, 0, ?, ?, ?, ?, ?, ?, 'companion', ?, ? WHERE EXISTS (SELECT 1 FROM users WHERE id = ?)`,
+		uid, conversation, card.OverallScore, string(fwBytes), card.Feedback, card.Tip, string(metricsBytes), string(localBytes), card.PromptVersion, card.SessionID, lang, uid)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errAccountDeleted
+	}
+	card.ID, _ = res.LastInsertId()
+
+	upd, err := tx.Exec(`UPDATE companion_sessions SET speech_id = ?, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND finished_at IS NULL`, card.ID, card.SessionID)
+	if err != nil {
+		return err
+	}
+	if n, _ := upd.RowsAffected(); n == 0 {
+		return errSessionFinished
+	}
+	return tx.Commit()
+}
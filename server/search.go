@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	searchPageDefault = 20
+	searchPageMax     = 50
+	searchMaxTerms    = 10
+	searchSnippetLen  = 16 // tokens around the match
+)
+
+// Snippet highlight markers. Control characters do not occur in speech
+// text, so snippets split on them safely and no HTML reaches the client.
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// searchFields are the indexed columns in speeches_fts order, with their
+// bm25 weights: a hit in the transcript counts more than one in feedback.
+var searchFields = []struct {
+	name   string
+	weight float64
+}{
+	{"transcript", 1.0},
+	{"feedback", 0.5},
+	{"tip", 0.5},
+}
+
+type SnippetPart struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+type SearchHit struct {
+	ID           int64         `json:"id"`
+	Date         time.Time     `json:"date"`
+	Kind         string        `json:"kind"`
+	Language     string        `json:"language"`
+	ClarityScore int           `json:"clarityScore"`
+	Field        string        `json:"field"` // where the snippet comes from
+	Snippet      []SnippetPart `json:"snippet"`
+}
+
+// ftsQuery turns what the user typed into an FTS5 query: every word must
+// occur, the last one as a prefix so results follow typing. Words are quoted,
+// so FTS5 operators in the input are searched for literally.
+func ftsQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\'' && r != '-'
+	})
+	if len(words) > searchMaxTerms {
+		words = words[:searchMaxTerms]
+	}
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		terms = append(terms, `"`+strings.ReplaceAll(w, `"`, `""`)+`"`)
+	}
+	if len(terms) > 0 {
+		terms[len(terms)-1] += "*"
+	}
+	return strings.Join(terms, " ")
+}
+
+// splitSnippet cuts an FTS5 snippet at the highlight markers.
+func splitSnippet(s string) []SnippetPart {
+	parts := []SnippetPart{}
+	for s != "" {
+		i := strings.Index(s, markStart)
+		if i < 0 {
+			parts = append(parts, SnippetPart{Text: s})
+			break
+		}
+		if i > 0 {
+			parts = append(parts, SnippetPart{Text: s[:i]})
+		}
+		s = s[i+len(markStart):]
+		j := strings.Index(s, markEnd)
+		if j < 0 {
+			j = len(s)
+		}
+		parts = append(parts, SnippetPart{Text: s[:j], Match: true})
+		s = strings.TrimPrefix(s[j:], markEnd)
+	}
+	return parts
+}
+
+// GET /api/speeches/search?q=&limit=&offset= finds the caller's speeches by
+// transcript, feedback and tip, best matches first.
+func handleSpeechSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, "Method not allowed", 405)
+		return
+	}
+	uid := r.Context().Value(userIDKey).(int)
+
+	match := ftsQuery(r.URL.Query().Get("q"))
+	if match == "" {
+		httpError(w, "Пустой запрос", 400)
+		return
+	}
+	limit, offset := searchPageDefault, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > searchPageMax {
+			httpError(w, fmt.Sprintf("limit must be 1-%d", searchPageMax), 400)
+			return
+		}
+		limit = n
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			httpError(w, "Invalid offset", 400)
+			return
+		}
+		offset = n
+	}
+
+	snippets := make([]string, len(searchFields))
+	weights := make([]string, len(searchFields))
+	for i, f := range searchFields {
+		snippets[i] = fmt.Sprintf("snippet(speeches_fts, %d, '%s', '%s', '…', %d)", i, markStart, markEnd, searchSnippetLen)
+		weights[i] = strconv.FormatFloat(f.weight, 'f', -1, 64)
+	}
+	query := fmt.Sprintf(`
+		SELECT s.id, s.created_at, COALESCE(s.kind, 'speech'), COALESCE(s.language, ?), s.clarity_score, %s
+		FROM speeches_fts
+		JOIN speeches s ON s.id = speeches_fts.rowid
+		WHERE speeches_fts MATCH ? AND s.user_id = ?
+		ORDER BY bm25(speeches_fts, %s), s.id DESC
+		LIMIT ? OFFSET ?`, strings.Join(snippets, ", "), strings.Join(weights, ", "))
+
+	rows, err := db.Query(query, defaultLanguage, match, uid, limit, offset)
+	if err != nil {
+		log.Println("[!] Search Error:", err)
+		httpError(w, "DB Query Error", 500)
+		return
+	}
+	defer rows.Close()
+
+	hits := []SearchHit{}
+	for rows.Next() {
+		var h SearchHit
+		texts := make([]sql.NullString, len(searchFields))
+		dest := []interface{}{&h.ID, &h.Date, &h.Kind, &h.Language, &h.ClarityScore}
+		for i := range texts {
+			dest = append(dest, &texts[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			log.Println("[!] Search Scan Error:", err)
+			continue
+		}
+		// The first field with a highlighted match supplies the snippet.
+		for i, t := range texts {
+			if strings.Contains(t.String, markStart) {
+				h.Field, h.Snippet = searchFields[i].name, splitSnippet(t.String)
+				break
+			}
+		}
+		if h.Field == "" {
+			h.Field, h.Snippet = searchFields[0].name, splitSnippet(texts[0].String)
+		}
+		hits = append(hits, h)
+	}
+	jsonResponse(w, map[string]interface{}{"query": r.URL.Query().Get("q"), "items": hits})
+}
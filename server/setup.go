@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -18,14 +19,15 @@ var (
 	jwtSecret []byte
 	db        *sql.DB
 	bot       *tgbotapi.BotAPI
-	gemini    *genai.GenerativeModel
+	llm       LLMProvider
 	otpStore  = make(map[string]*OtpSession)
 	otpMutex  sync.Mutex
 )
 
 func initDB() {
 	var err error
-	db, err = sql.Open("sqlite", "./orato.db")
+	// WAL + busy_timeout: analysis workers and HTTP handlers write concurrently.
+	db, err = sql.Open("sqlite", "./orato.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
 	if err != nil {
 		log.Fatal("[!] DB Connection Error:", err)
 	}
@@ -59,16 +61,177 @@ func initDB() {
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY(user_id) REFERENCES users(id)
 	);
+	CREATE INDEX IF NOT EXISTS idx_speeches_user_created ON speeches(user_id, created_at);
+	CREATE TABLE IF NOT EXISTS speech_tags (
+		speech_id INTEGER,
+		tag TEXT,                 -- в нижнем регистре
+		PRIMARY KEY(speech_id, tag),
+		FOREIGN KEY(speech_id) REFERENCES speeches(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_speech_tags_tag ON speech_tags(tag);
+	CREATE TABLE IF NOT EXISTS companion_sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER,
+		mode TEXT,
+		language TEXT,
+		summary TEXT DEFAULT '',         -- Сжатый пересказ старых реплик
+		summarized_until INTEGER DEFAULT 0, -- id последнего сообщения, вошедшего в summary
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS companion_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id INTEGER,
+		role TEXT,                -- user | model
+		content TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(session_id) REFERENCES companion_sessions(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_companion_messages_session ON companion_messages(session_id);
+	CREATE TABLE IF NOT EXISTS personas (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER,          -- владелец; встроенные персоны живут в personas.json
+		name TEXT,
+		role TEXT,
+		tone TEXT DEFAULT '',
+		task TEXT DEFAULT '',
+		opening TEXT DEFAULT '',
+		temperature REAL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS analysis_jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER,
+		request TEXT,                  -- AnalyzeRequest JSON
+		status TEXT DEFAULT 'queued',  -- queued | running | done | failed
+		attempts INTEGER DEFAULT 0,
+		result TEXT,
+		error TEXT,
+		speech_id INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_analysis_jobs_status ON analysis_jobs(status);
+	CREATE TABLE IF NOT EXISTS analysis_cache (
+		key TEXT PRIMARY KEY,    -- sha256(transcript, язык, версия промпта, модель)
+		result TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at INTEGER       -- unix time
+	);
+	CREATE TABLE IF NOT EXISTS rewarded_transcripts (
+		user_id INTEGER,
+		hash TEXT,               -- sha256 нормализованного текста речи
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(user_id, hash),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS llm_usage (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER,          -- NULL для фоновых вызовов без пользователя
+		feature TEXT,             -- analysis | companion | companion_summary | ...
+		provider TEXT,
+		model TEXT,
+		prompt_tokens INTEGER DEFAULT 0,
+		response_tokens INTEGER DEFAULT 0,
+		latency_ms INTEGER,
+		success BOOLEAN,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_llm_usage_user ON llm_usage(user_id, created_at);
+	CREATE TABLE IF NOT EXISTS speech_rewrites (
+		speech_id INTEGER PRIMARY KEY,
+		rewritten TEXT,
+		prompt_version TEXT,     -- переписываем заново при смене шаблона
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(speech_id) REFERENCES speeches(id)
+	);
+	CREATE TABLE IF NOT EXISTS scripts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER,
+		title TEXT,
+		content TEXT,             -- заготовленный текст выступления
+		language TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS training_plans (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER,
+		week_start TEXT,          -- понедельник недели (UTC), YYYY-MM-DD
+		focus TEXT,               -- PlanFocus JSON: слабые метрики на момент создания
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(user_id, week_start),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS training_plan_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		plan_id INTEGER,
+		position INTEGER,
+		metric TEXT,
+		exercise INTEGER,         -- индекс в planExercises[metric]
+		topic_id INTEGER,
+		target INTEGER,           -- оценка по metric, закрывающая пункт
+		speech_id INTEGER,        -- речь, закрывшая пункт
+		done_at DATETIME,
+		FOREIGN KEY(plan_id) REFERENCES training_plans(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_training_plan_items_plan ON training_plan_items(plan_id);
 	CREATE TABLE IF NOT EXISTS topics (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		text_ru TEXT,
 		text_en TEXT
-	);`
+	);
+	CREATE TABLE IF NOT EXISTS topic_translations (
+		topic_id INTEGER,
+		language TEXT,           -- код из реестра языков
+		text TEXT,
+		PRIMARY KEY(topic_id, language),
+		FOREIGN KEY(topic_id) REFERENCES topics(id)
+	);
+	CREATE TABLE IF NOT EXISTS account_audit (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER,          -- без FOREIGN KEY: запись переживает удаление аккаунта
+		action TEXT,              -- export | delete
+		reauth TEXT,              -- чем подтверждено удаление: password, telegram, fresh_login
+		details TEXT,             -- JSON: сколько строк удалено по таблицам
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	-- Полнотекстовый поиск по речам; индекс ведут триггеры
+	CREATE VIRTUAL TABLE IF NOT EXISTS speeches_fts USING fts5(
+		transcript, feedback, tip,
+		content='speeches', content_rowid='id',
+		tokenize='unicode61 remove_diacritics 2'
+	);
+	CREATE TRIGGER IF NOT EXISTS speeches_fts_insert AFTER INSERT ON speeches BEGIN
+		INSERT INTO speeches_fts(rowid, transcript, feedback, tip) VALUES (new.id, new.transcript, new.feedback, new.tip);
+	END;
+	CREATE TRIGGER IF NOT EXISTS speeches_fts_delete AFTER DELETE ON speeches BEGIN
+		INSERT INTO speeches_fts(speeches_fts, rowid, transcript, feedback, tip) VALUES ('delete', old.id, old.transcript, old.feedback, old.tip);
+	END;
+	CREATE TRIGGER IF NOT EXISTS speeches_fts_update AFTER UPDATE OF transcript, feedback, tip ON speeches BEGIN
+		INSERT INTO speeches_fts(speeches_fts, rowid, transcript, feedback, tip) VALUES ('delete', old.id, old.transcript, old.feedback, old.tip);
+		INSERT INTO speeches_fts(rowid, transcript, feedback, tip) VALUES (new.id, new.transcript, new.feedback, new.tip);
+	END;`
+
+	var hasSearchIndex int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'speeches_fts'`).Scan(&hasSearchIndex)
 
 	_, err = db.Exec(query)
 	if err != nil {
 		log.Fatal("[!] DB Init Error:", err)
 	}
+	migrateDB()
+
+	// Речи, сохраненные до появления поиска, индексируем один раз
+	if hasSearchIndex == 0 {
+		if _, err := db.Exec(`INSERT INTO speeches_fts(speeches_fts) VALUES ('rebuild')`); err != nil {
+			log.Println("[!] Search Index Rebuild Error:", err)
+		}
+	}
 
 	// Seeding topics
 	var count int
@@ -105,9 +268,52 @@ func initDB() {
 		}
 	}
 
+	// Переводы тем из старых колонок text_ru / text_en
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO topic_translations (topic_id, language, text)
+		SELECT id, 'ru', text_ru FROM topics WHERE text_ru IS NOT NULL
+		UNION ALL
+		SELECT id, 'en', text_en FROM topics WHERE text_en IS NOT NULL`)
+	if err != nil {
+		log.Println("[!] Topic Translation Error:", err)
+	}
+
 	fmt.Println("[+] Database initialized successfully (Orato v2)")
 }
 
+// Columns added to existing tables after the first release. SQLite has no
+// ADD COLUMN IF NOT EXISTS, so "duplicate column" errors are expected.
+var columnMigrations = []string{
+	`ALTER TABLE speeches ADD COLUMN local_metrics TEXT DEFAULT '{}'`,
+	`ALTER TABLE speeches ADD COLUMN pace_analysis TEXT DEFAULT '{}'`,
+	`ALTER TABLE speeches ADD COLUMN prompt_version TEXT`, // шаблон промпта, давший оценку
+	// Лимиты токенов; NULL = значение по умолчанию из USAGE_*_TOKENS
+	`ALTER TABLE users ADD COLUMN daily_token_quota INTEGER`,
+	`ALTER TABLE users ADD COLUMN monthly_token_quota INTEGER`,
+	`ALTER TABLE speeches ADD COLUMN kind TEXT DEFAULT 'speech'`,  // speech | companion
+	`ALTER TABLE speeches ADD COLUMN session_id INTEGER`,          // для kind = companion
+	`ALTER TABLE companion_sessions ADD COLUMN speech_id INTEGER`, // итоговая оценка после finish
+	`ALTER TABLE speeches ADD COLUMN language TEXT`,
+	`ALTER TABLE speeches ADD COLUMN script_id INTEGER`, // репетиция по сценарию
+	`ALTER TABLE speeches ADD COLUMN rehearsal TEXT`,    // RehearsalResult JSON
+	`ALTER TABLE speeches ADD COLUMN topic_id INTEGER`,  // тема из /api/topics/random
+	`ALTER TABLE speeches ADD COLUMN notes TEXT`,        // заметки пользователя
+	// Сессия остаётся завершённой, даже если её оценку удалили
+	`ALTER TABLE companion_sessions ADD COLUMN finished_at DATETIME`,
+	`ALTER TABLE analysis_cache ADD COLUMN user_id INTEGER`, // чья речь; удаляется вместе с аккаунтом
+}
+
+func migrateDB() {
+	for _, q := range columnMigrations {
+		if _, err := db.Exec(q); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			log.Fatal("[!] DB Migration Error:", err)
+		}
+	}
+	db.Exec(`UPDATE companion_sessions SET finished_at = updated_at WHERE speech_id IS NOT NULL AND finished_at IS NULL`)
+	// Оценки из кэша без владельца нельзя удалить вместе с аккаунтом
+	db.Exec(`DELETE FROM analysis_cache WHERE user_id IS NULL`)
+}
+
 func initTelegram() {
 	token := os.Getenv("TELEGRAM_BOT_TOKEN")
 	if token == "" {
@@ -138,27 +344,46 @@ func initTelegram() {
 	}
 }
 
-func initGemini() {
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		log.Fatal("[!] GEMINI_API_KEY is missing")
+// initLLM picks the model backend from LLM_PROVIDER (gemini | openai | fake),
+// Gemini by default. The offline fake returns canned scores, so it is only
+// used when asked for by name.
+func initLLM() {
+	provider := os.Getenv("LLM_PROVIDER")
+	if provider == "" {
+		provider = "gemini"
 	}
+	modelName := os.Getenv("LLM_MODEL")
+	loadGenerationOverrides()
 
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
-	if err != nil {
-		log.Fatal("[!] Gemini Client Error:", err)
+	switch provider {
+	case "gemini":
+		apiKey := os.Getenv("GEMINI_API_KEY")
+		if apiKey == "" {
+			log.Fatal("[!] GEMINI_API_KEY is missing")
+		}
+		client, err := genai.NewClient(context.Background(), option.WithAPIKey(apiKey))
+		if err != nil {
+			log.Fatal("[!] Gemini Client Error:", err)
+		}
+		if modelName == "" {
+			modelName = "gemini-2.5-flash"
+		}
+		llm = newGeminiProvider(client, modelName)
+	case "openai":
+		baseURL := os.Getenv("OPENAI_BASE_URL")
+		if baseURL == "" {
+			baseURL = "https://api.openai.com/v1"
+		}
+		if modelName == "" {
+			modelName = "gpt-4o-mini"
+		}
+		llm = newOpenAIProvider(baseURL, os.Getenv("OPENAI_API_KEY"), modelName)
+	case "fake":
+		log.Println("[!] WARNING: using the fake LLM provider, scores are not real")
+		llm = fakeProvider{}
+	default:
+		log.Fatal("[!] Unknown LLM_PROVIDER: ", provider)
 	}
 
-	modelName := "gemini-2.5-flash"
-	fmt.Printf("[+] Connected to model: %s\n", modelName)
-	gemini = client.GenerativeModel(modelName)
-
-	gemini.SafetySettings = []*genai.SafetySetting{
-		{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockNone},
-		{Category: genai.HarmCategoryHateSpeech, Threshold: genai.HarmBlockNone},
-		{Category: genai.HarmCategorySexuallyExplicit, Threshold: genai.HarmBlockNone},
-		{Category: genai.HarmCategoryDangerousContent, Threshold: genai.HarmBlockNone},
-	}
-	gemini.SetTemperature(0.7)
+	fmt.Printf("[+] LLM provider: %s (model: %s)\n", llm.Name(), llm.DefaultModel())
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// LocalMetrics are computed in Go straight from the transcript, so the same
+// speech always gets the same numbers regardless of what the LLM thinks.
+type LocalMetrics struct {
+	WordCount         int            `json:"wordCount"`
+	SentenceCount     int            `json:"sentenceCount"`
+	Fillers           map[string]int `json:"fillers"`
+	FillerCount       int            `json:"fillerCount"`
+	FillerRate        float64        `json:"fillerRate"` // per 100 words
+	Hedges            map[string]int `json:"hedges"`
+	HedgeCount        int            `json:"hedgeCount"`
+	LexicalDiversity  float64        `json:"lexicalDiversity"`  // moving-average type/token ratio, 0-1
+	AvgSentenceLength float64        `json:"avgSentenceLength"` // words per sentence
+	RepetitionRate    float64        `json:"repetitionRate"`    // share of words that are repeats, 0-1
+}
+
+// Window for the moving-average type/token ratio. Plain TTR falls as texts
+// get longer, which would punish longer speeches.
+const diversityWindow = 50
+
+func computeLocalMetrics(transcript, lang string) *LocalMetrics {
+	l := languageFor(lang)
+	fillers, hedges := l.Fillers, l.Hedges
+
+	words := tokenizeWords(transcript)
+	m := &LocalMetrics{
+		WordCount:     len(words),
+		SentenceCount: countSentences(transcript),
+	}
+	m.Fillers, m.FillerCount = countPhrases(words, fillers)
+	m.Hedges, m.HedgeCount = countPhrases(words, hedges)
+
+	if m.WordCount > 0 {
+		m.FillerRate = round3(float64(m.FillerCount) * 100 / float64(m.WordCount))
+		m.LexicalDiversity = round3(movingTTR(words, diversityWindow))
+		m.RepetitionRate = round3(float64(countRepeats(words)) / float64(m.WordCount))
+	}
+	if m.SentenceCount > 0 {
+		m.AvgSentenceLength = round3(float64(m.WordCount) / float64(m.SentenceCount))
+	}
+	return m
+}
+
+// tokenizeWords lowercases and splits on anything that is not a letter,
+// digit, apostrophe or inner hyphen ("вообще-то", "don't").
+func tokenizeWords(text string) []string {
+	runes := []rune(strings.ToLower(text))
+	var words []string
+	start := -1
+	for i, r := range runes {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\'' || r == '’' ||
+			r == '-' && i > 0 && i+1 < len(runes) && unicode.IsLetter(runes[i-1]) && unicode.IsLetter(runes[i+1])
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			words = append(words, string(runes[start:i]))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+// countSentences counts runs of terminal punctuation. Browser STT often
+// returns no punctuation at all; that is one sentence, not zero.
+func countSentences(text string) int {
+	n, inText := 0, false
+	for _, r := range text {
+		switch {
+		case r == '.' || r == '!' || r == '?' || r == '…':
+			if inText {
+				n++
+			}
+			inText = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			inText = true
+		}
+	}
+	if inText || (n == 0 && strings.TrimSpace(text) != "") {
+		n++
+	}
+	return n
+}
+
+// countPhrases matches single words and multi-word phrases ("как бы",
+// "you know") greedily, longest phrase first, without overlaps.
+func countPhrases(words []string, dict []string) (map[string]int, int) {
+	phrases := make([][]string, 0, len(dict))
+	for _, p := range dict {
+		phrases = append(phrases, strings.Fields(p))
+	}
+
+	counts := map[string]int{}
+	total := 0
+	for i := 0; i < len(words); {
+		best := 0
+		for _, p := range phrases {
+			if len(p) > best && i+len(p) <= len(words) && equalWords(words[i:i+len(p)], p) {
+				best = len(p)
+			}
+		}
+		if best == 0 {
+			i++
+			continue
+		}
+		counts[strings.Join(words[i:i+best], " ")]++
+		total++
+		i += best
+	}
+	return counts, total
+}
+
+func equalWords(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func movingTTR(words []string, window int) float64 {
+	if len(words) <= window {
+		return float64(len(uniqueWords(words))) / float64(len(words))
+	}
+	sum := 0.0
+	for i := 0; i+window <= len(words); i++ {
+		sum += float64(len(uniqueWords(words[i:i+window]))) / float64(window)
+	}
+	return sum / float64(len(words)-window+1)
+}
+
+func uniqueWords(words []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		set[w] = struct{}{}
+	}
+	return set
+}
+
+// countRepeats counts stutters ("I I", "это это") and every repeat of a
+// three-word sequence after its first occurrence.
+func countRepeats(words []string) int {
+	n := 0
+	for i := 1; i < len(words); i++ {
+		if words[i] == words[i-1] {
+			n++
+		}
+	}
+	seen := map[string]bool{}
+	for i := 0; i+3 <= len(words); i++ {
+		key := strings.Join(words[i:i+3], " ")
+		if seen[key] {
+			n++
+		}
+		seen[key] = true
+	}
+	return min(n, len(words))
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizeWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, World!", []string{"hello", "world"}},
+		{"Вообще-то я don't знаю", []string{"вообще-то", "я", "don't", "знаю"}},
+		{"it’s well-known", []string{"it’s", "well-known"}},
+		{"- dash -- here- -there", []string{"dash", "here", "there"}},
+		{"pages 10-12", []string{"pages", "10", "12"}},
+		{"a-b-c", []string{"a-b-c"}},
+		{"  ...  ", nil},
+	}
+	for _, tt := range tests {
+		if got := tokenizeWords(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenizeWords(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCountSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"   ", 0},
+		{"no punctuation at all", 1},
+		{"One. Two! Three?", 3},
+		{"Wait... what?!", 2},
+		{"Trailing words after. the last stop", 2},
+		{"...", 1},
+	}
+	for _, tt := range tests {
+		if got := countSentences(tt.text); got != tt.want {
+			t.Errorf("countSentences(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCountPhrases(t *testing.T) {
+	dict := []string{"как бы", "как", "you know", "um"}
+	tests := []struct {
+		text   string
+		counts map[string]int
+		total  int
+	}{
+		{"это как бы важно", map[string]int{"как бы": 1}, 1},
+		{"как дела, как бы", map[string]int{"как": 1, "как бы": 1}, 2},
+		{"um, you know, um", map[string]int{"um": 2, "you know": 1}, 3},
+		{"do you know him", map[string]int{"you know": 1}, 1},
+		{"nothing here", map[string]int{}, 0},
+	}
+	for _, tt := range tests {
+		counts, total := countPhrases(tokenizeWords(tt.text), dict)
+		if total != tt.total || !reflect.DeepEqual(counts, tt.counts) {
+			t.Errorf("countPhrases(%q) = %v, %d; want %v, %d", tt.text, counts, total, tt.counts, tt.total)
+		}
+	}
+}
+
+func TestCountRepeats(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"a b c d", 0},
+		{"I I think", 1},
+		{"это это это", 2},
+		{"we can do it and we can do it", 2}, // "we can do" and "can do it" again
+		{"ну ну ну ну", 4},                   // three stutters and a repeated trigram
+	}
+	for _, tt := range tests {
+		if got := countRepeats(tokenizeWords(tt.text)); got != tt.want {
+			t.Errorf("countRepeats(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestMovingTTR(t *testing.T) {
+	if got := movingTTR([]string{"a", "b", "a", "c"}, 50); got != 0.75 {
+		t.Errorf("short text: %v, want 0.75", got)
+	}
+	// Windows of 2 over a a b b: {a}, {a b}, {b}.
+	if got := movingTTR([]string{"a", "a", "b", "b"}, 2); got != (0.5+1+0.5)/3 {
+		t.Errorf("windowed: %v", got)
+	}
+}
+
+func TestComputeLocalMetrics(t *testing.T) {
+	m := computeLocalMetrics("Um, I like it. I think it's, you know, literally great!", "en")
+	want := &LocalMetrics{
+		WordCount:         11,
+		SentenceCount:     2,
+		Fillers:           map[string]int{"um": 1, "you know": 1},
+		FillerCount:       2,
+		FillerRate:        18.182,
+		Hedges:            map[string]int{"i think": 1},
+		HedgeCount:        1,
+		LexicalDiversity:  0.909,
+		AvgSentenceLength: 5.5,
+		RepetitionRate:    0,
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("computeLocalMetrics:\n got %+v\nwant %+v", m, want)
+	}
+
+	empty := computeLocalMetrics("", "ru")
+	if empty.WordCount != 0 || empty.FillerRate != 0 || empty.LexicalDiversity != 0 || empty.AvgSentenceLength != 0 {
+		t.Errorf("empty transcript: %+v", empty)
+	}
+}
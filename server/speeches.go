@@ -0,0 +1,516 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	speechPageDefault = 20
+	speechPageMax     = 100
+	speechExcerptLen  = 160 // characters of the transcript in the list view
+)
+
+// speechSorts maps the sort parameter to the column it orders by. Every
+// order is made total by the id, which is what the cursor resumes from.
+var speechSorts = map[string]struct {
+	column string
+	desc   bool
+}{
+	"date_desc":    {"s.created_at", true},
+	"date_asc":     {"s.created_at", false},
+	"clarity_desc": {"s.clarity_score", true},
+	"clarity_asc":  {"s.clarity_score", false},
+}
+
+// SpeechItem is one history entry. The list view carries an excerpt; the
+// full view adds the transcript and everything computed from it.
+type SpeechItem struct {
+	ID            int64           `json:"id"`
+	Date          time.Time       `json:"date"`
+	Kind          string          `json:"kind"`
+	Language      string          `json:"language"`
+	ClarityScore  int             `json:"clarityScore"`
+	Pace          int             `json:"pace"`
+	Metrics       json.RawMessage `json:"metrics"`
+	FillerWords   json.RawMessage `json:"fillerWords"`
+	PromptVersion string          `json:"promptVersion,omitempty"`
+	ScriptID      *int64          `json:"scriptId,omitempty"`
+	TopicID       *int64          `json:"topicId,omitempty"`
+	Tags          []string        `json:"tags"`
+	Excerpt       string          `json:"excerpt,omitempty"`
+	Transcript    string          `json:"transcript,omitempty"`
+	Feedback      string          `json:"feedback,omitempty"`
+	Tip           string          `json:"tip,omitempty"`
+	LocalMetrics  json.RawMessage `json:"localMetrics,omitempty"`
+	PaceAnalysis  json.RawMessage `json:"paceAnalysis,omitempty"`
+	Rehearsal     json.RawMessage `json:"rehearsal,omitempty"`
+	Notes         string          `json:"notes,omitempty"`
+}
+
+type SpeechPage struct {
+	Items      []SpeechItem `json:"items"`
+	NextCursor string       `json:"nextCursor,omitempty"` // absent on the last page
+}
+
+// speechCursor is the position after the last item of a page: its sort
+// value and id. It is opaque to clients.
+type speechCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+}
+
+func (c speechCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSpeechCursor(s string) (speechCursor, error) {
+	var c speechCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	return c, json.Unmarshal(b, &c)
+}
+
+// nullableJSON keeps stored JSON columns valid in the response: legacy rows
+// have NULL or "" where newer ones have an object.
+func nullableJSON(s sql.NullString, empty string) json.RawMessage {
+	if !s.Valid || strings.TrimSpace(s.String) == "" || !json.Valid([]byte(s.String)) {
+		return json.RawMessage(empty)
+	}
+	return json.RawMessage(s.String)
+}
+
+// GET /api/speeches lists the caller's speeches a page at a time.
+//
+// Filters: from, to (YYYY-MM-DD, inclusive), language, minClarity,
+// maxClarity, topic (topic id), tag, kind (speech | companion).
+// sort: date_desc (default), date_asc, clarity_desc, clarity_asc.
+// view: list (default, no transcripts) or full. limit: 1-100.
+// /api/history keeps returning everything in the old shape.
+func handleSpeeches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, "Method not allowed", 405)
+		return
+	}
+	uid := r.Context().Value(userIDKey).(int)
+	q := r.URL.Query()
+
+	where := []string{"s.user_id = ?"}
+	args := []interface{}{uid}
+
+	for _, p := range []struct{ name, cond string }{
+		{"from", "s.created_at >= date(?)"},
+		{"to", "s.created_at < date(?, '+1 day')"},
+	} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			httpError(w, fmt.Sprintf("Invalid %s: expected YYYY-MM-DD", p.name), 400)
+			return
+		}
+		where = append(where, p.cond)
+		args = append(args, v)
+	}
+	if v := q.Get("language"); v != "" {
+		lang, ok := requireLanguage(w, v)
+		if !ok {
+			return
+		}
+		// Speeches saved before languages were recorded are in the default.
+		where = append(where, "COALESCE(s.language, ?) = ?")
+		args = append(args, defaultLanguage, lang.Code)
+	}
+	for _, p := range []struct{ name, cond string }{
+		{"minClarity", "s.clarity_score >= ?"},
+		{"maxClarity", "s.clarity_score <= ?"},
+		{"topic", "s.topic_id = ?"},
+	} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			httpError(w, "Invalid "+p.name, 400)
+			return
+		}
+		where = append(where, p.cond)
+		args = append(args, n)
+	}
+	if v := strings.TrimSpace(q.Get("tag")); v != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM speech_tags t WHERE t.speech_id = s.id AND t.tag = ?)")
+		args = append(args, strings.ToLower(v))
+	}
+	switch v := q.Get("kind"); v {
+	case "":
+	case "speech", "companion":
+		where = append(where, "COALESCE(s.kind, 'speech') = ?")
+		args = append(args, v)
+	default:
+		httpError(w, "Invalid kind", 400)
+		return
+	}
+
+	sortName := q.Get("sort")
+	if sortName == "" {
+		sortName = "date_desc"
+	}
+	order, ok := speechSorts[sortName]
+	if !ok {
+		httpError(w, "Invalid sort", 400)
+		return
+	}
+	dir, cmp := "ASC", ">"
+	if order.desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeSpeechCursor(v)
+		if err != nil || c.Sort != sortName {
+			httpError(w, "Invalid cursor", 400)
+			return
+		}
+		where = append(where, fmt.Sprintf("(%s, s.id) %s (?, ?)", order.column, cmp))
+		if order.column == "s.clarity_score" {
+			n, _ := strconv.Atoi(c.Value)
+			args = append(args, n, c.ID)
+		} else {
+			args = append(args, c.Value, c.ID)
+		}
+	}
+
+	limit := speechPageDefault
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > speechPageMax {
+			httpError(w, fmt.Sprintf("limit must be 1-%d", speechPageMax), 400)
+			return
+		}
+		limit = n
+	}
+
+	full := false
+	switch q.Get("view") {
+	case "", "list":
+	case "full":
+		full = true
+	default:
+		httpError(w, "Invalid view", 400)
+		return
+	}
+
+	// The list view only needs enough of the transcript for the excerpt.
+	transcript := "s.transcript"
+	if !full {
+		transcript = fmt.Sprintf("substr(s.transcript, 1, %d)", speechExcerptLen+1)
+	}
+
+	// One row more than the page tells whether there is a next one.
+	query := fmt.Sprintf(`%s
+		WHERE %s
+		ORDER BY %s %s, s.id %s
+		LIMIT ?`, speechSelect(transcript), strings.Join(where, " AND "), order.column, dir, dir)
+	args = append([]interface{}{defaultLanguage}, args...)
+	args = append(args, limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Println("[!] Speech List Error:", err)
+		httpError(w, "DB Query Error", 500)
+		return
+	}
+	defer rows.Close()
+
+	page := SpeechPage{Items: []SpeechItem{}}
+	var prevValue string
+	for rows.Next() {
+		it, dateText, err := scanSpeech(rows, full)
+		if err != nil {
+			log.Println("[!] Speech List Scan Error:", err)
+			continue
+		}
+		if len(page.Items) == limit {
+			c := speechCursor{Sort: sortName, ID: page.Items[limit-1].ID, Value: prevValue}
+			page.NextCursor = c.encode()
+			break
+		}
+		page.Items = append(page.Items, *it)
+		prevValue = dateText
+		if order.column == "s.clarity_score" {
+			prevValue = strconv.Itoa(it.ClarityScore)
+		}
+	}
+	jsonResponse(w, page)
+}
+
+// speechSelect is the SELECT ... FROM part shared by the speech endpoints;
+// its first parameter is defaultLanguage. transcript is the column
+// expression to read the transcript with.
+func speechSelect(transcript string) string {
+	return fmt.Sprintf(`
+		SELECT s.id, s.created_at, CAST(s.created_at AS TEXT), COALESCE(s.kind, 'speech'), COALESCE(s.language, ?),
+			s.clarity_score, s.pace_wpm, s.metrics, s.filler_words, s.prompt_version, s.script_id, s.topic_id,
+			(SELECT json_group_array(tag) FROM (SELECT tag FROM speech_tags WHERE speech_id = s.id ORDER BY tag)),
+			%s, s.feedback, s.tip, s.local_metrics, s.pace_analysis, s.rehearsal, s.notes
+		FROM speeches s`, transcript)
+}
+
+// scanSpeech reads a speechSelect row. It also returns created_at as
+// stored, which is what cursors compare against.
+func scanSpeech(row interface{ Scan(...interface{}) error }, full bool) (*SpeechItem, string, error) {
+	var it SpeechItem
+	var dateText string
+	var metrics, fillers, promptVersion, tags, feedback, tip, local, pace, rehearsal, notes sql.NullString
+	var scriptID, topicID sql.NullInt64
+	if err := row.Scan(&it.ID, &it.Date, &dateText, &it.Kind, &it.Language, &it.ClarityScore, &it.Pace,
+		&metrics, &fillers, &promptVersion, &scriptID, &topicID, &tags,
+		&it.Transcript, &feedback, &tip, &local, &pace, &rehearsal, &notes); err != nil {
+		return nil, "", err
+	}
+
+	it.Metrics = nullableJSON(metrics, "{}")
+	it.FillerWords = nullableJSON(fillers, "[]")
+	it.PromptVersion = promptVersion.String
+	if scriptID.Valid {
+		it.ScriptID = &scriptID.Int64
+	}
+	if topicID.Valid {
+		it.TopicID = &topicID.Int64
+	}
+	json.Unmarshal([]byte(tags.String), &it.Tags)
+	if it.Tags == nil {
+		it.Tags = []string{}
+	}
+
+	if full {
+		it.Feedback, it.Tip, it.Notes = feedback.String, tip.String, notes.String
+		it.LocalMetrics = nullableJSON(local, "{}")
+		it.PaceAnalysis = nullableJSON(pace, "{}")
+		if rehearsal.Valid {
+			it.Rehearsal = nullableJSON(rehearsal, "null")
+		}
+	} else {
+		it.Excerpt = preview(it.Transcript, speechExcerptLen)
+		it.Transcript = ""
+	}
+	return &it, dateText, nil
+}
+
+const (
+	maxSpeechNotes = 2000
+	maxSpeechTags  = 10
+	maxTagLength   = 32
+)
+
+// normalizeTags lowercases, trims and de-duplicates tags. Tags are free-form
+// but short: letters, digits, spaces, '-' and '_'.
+func normalizeTags(in []string) ([]string, error) {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, t := range in {
+		t = strings.Join(strings.Fields(strings.ToLower(t)), " ")
+		if t == "" || seen[t] {
+			continue
+		}
+		if utf8.RuneCountInString(t) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", t, maxTagLength)
+		}
+		for _, r := range t {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' && r != '_' {
+				return nil, fmt.Errorf("tag %q may only contain letters, digits, spaces, '-' and '_'", t)
+			}
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	if len(tags) > maxSpeechTags {
+		return nil, fmt.Errorf("at most %d tags per speech", maxSpeechTags)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func loadSpeech(uid int, id int64) (*SpeechItem, error) {
+	row := db.QueryRow(speechSelect("s.transcript")+` WHERE s.id = ? AND s.user_id = ?`, defaultLanguage, id, uid)
+	it, _, err := scanSpeech(row, true)
+	return it, err
+}
+
+// deleteSpeeches removes speeches matching where (a condition on speeches
+// with its arguments) together with the rows that hang off them. A finished
+// companion session loses its scorecard but stays finished, a plan item the
+// speech completed is open again, and the analysis job goes too: it holds
+// the transcript and the evaluation.
+func deleteSpeeches(tx *sql.Tx, where string, args ...interface{}) (int64, error) {
+	for _, q := range []string{
+		`DELETE FROM speech_tags WHERE speech_id IN (SELECT id FROM speeches WHERE %s)`,
+		`DELETE FROM speech_rewrites WHERE speech_id IN (SELECT id FROM speeches WHERE %s)`,
+		`DELETE FROM analysis_jobs WHERE speech_id IN (SELECT id FROM speeches WHERE %s)`,
+		`UPDATE companion_sessions SET speech_id = NULL WHERE speech_id IN (SELECT id FROM speeches WHERE %s)`,
+		`UPDATE training_plan_items SET speech_id = NULL, done_at = NULL WHERE speech_id IN (SELECT id FROM speeches WHERE %s)`,
+	} {
+		if _, err := tx.Exec(fmt.Sprintf(q, where), args...); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.Exec(`DELETE FROM speeches WHERE `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// /api/speeches/{id}: GET returns the speech in the full view, PATCH
+// updates notes and/or replaces the tags, DELETE removes it.
+func handleSpeech(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(userIDKey).(int)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		httpError(w, "Invalid speech id", 400)
+		return
+	}
+	it, err := loadSpeech(uid, id)
+	if err == sql.ErrNoRows {
+		httpError(w, "Speech not found", 404)
+		return
+	}
+	if err != nil {
+		log.Println("[!] Speech Read Error:", err)
+		httpError(w, "DB Query Error", 500)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		jsonResponse(w, it)
+
+	case "PATCH":
+		var req struct {
+			Notes *string   `json:"notes"`
+			Tags  *[]string `json:"tags"`
+		}
+		if json.NewDecoder(r.Body).Decode(&req) != nil {
+			httpError(w, "Invalid JSON", 400)
+			return
+		}
+		var tags []string
+		if req.Tags != nil {
+			if tags, err = normalizeTags(*req.Tags); err != nil {
+				httpError(w, err.Error(), 400)
+				return
+			}
+		}
+		if req.Notes != nil {
+			*req.Notes = strings.TrimSpace(*req.Notes)
+			if utf8.RuneCountInString(*req.Notes) > maxSpeechNotes {
+				httpError(w, fmt.Sprintf("Notes are longer than %d characters", maxSpeechNotes), 400)
+				return
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			httpError(w, "DB Error", 500)
+			return
+		}
+		defer tx.Rollback()
+		if req.Notes != nil {
+			if _, err := tx.Exec(`UPDATE speeches SET notes = ? WHERE id = ? AND user_id = ?`, *req.Notes, id, uid); err != nil {
+				httpError(w, "Failed to update speech", 500)
+				return
+			}
+		}
+		if req.Tags != nil {
+			if _, err := tx.Exec(`DELETE FROM speech_tags WHERE speech_id = ?`, id); err != nil {
+				httpError(w, "Failed to update speech", 500)
+				return
+			}
+			for _, t := range tags {
+				if _, err := tx.Exec(`INSERT INTO speech_tags (speech_id, tag) VALUES (?, ?)`, id, t); err != nil {
+					httpError(w, "Failed to update speech", 500)
+					return
+				}
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			httpError(w, "Failed to update speech", 500)
+			return
+		}
+
+		if it, err = loadSpeech(uid, id); err != nil {
+			httpError(w, "DB Query Error", 500)
+			return
+		}
+		jsonResponse(w, it)
+
+	case "DELETE":
+		tx, err := db.Begin()
+		if err != nil {
+			httpError(w, "DB Error", 500)
+			return
+		}
+		defer tx.Rollback()
+		if _, err := deleteSpeeches(tx, "id = ? AND user_id = ?", id, uid); err != nil || tx.Commit() != nil {
+			log.Println("[!] Speech Delete Error:", err)
+			httpError(w, "Failed to delete speech", 500)
+			return
+		}
+		jsonResponse(w, map[string]string{"msg": "Speech deleted"})
+
+	default:
+		httpError(w, "Method not allowed", 405)
+	}
+}
+
+// GET /api/speeches/tags lists the caller's tags with how many speeches
+// carry each, most used first.
+func handleSpeechTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, "Method not allowed", 405)
+		return
+	}
+	uid := r.Context().Value(userIDKey).(int)
+
+	rows, err := db.Query(`
+		SELECT t.tag, COUNT(*) FROM speech_tags t
+		JOIN speeches s ON s.id = t.speech_id
+		WHERE s.user_id = ?
+		GROUP BY t.tag
+		ORDER BY COUNT(*) DESC, t.tag`, uid)
+	if err != nil {
+		httpError(w, "DB Query Error", 500)
+		return
+	}
+	defer rows.Close()
+
+	type tagCount struct {
+		Tag   string `json:"tag"`
+		Count int    `json:"count"`
+	}
+	res := []tagCount{}
+	for rows.Next() {
+		var t tagCount
+		if rows.Scan(&t.Tag, &t.Count) == nil {
+			res = append(res, t)
+		}
+	}
+	jsonResponse(w, res)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	statsDefaultDays   = 90
+	statsMaxBuckets    = 400
+	statsDefaultWindow = 3
+	statsTopFillers    = 10
+	paceBinWidth       = 20
+	paceBinMin         = 60 // below this is one "slow" bin
+	paceBinMax         = 220
+)
+
+// statsMetrics are the per-speech scores tracked over time: the overall
+// clarity score and the AnalysisMetrics.
+var statsMetrics = []string{"clarity", "confidence", "vocabulary", "structure", "empathy", "conciseness"}
+
+type MetricPoint struct {
+	Start   string   `json:"start"` // first day of the bucket
+	Average *float64 `json:"average"`
+	Count   int      `json:"count"`
+	Moving  *float64 `json:"movingAverage"` // over the last `window` buckets with data
+}
+
+type MetricSeries struct {
+	Average      *float64      `json:"average"`
+	TrendPerWeek *float64      `json:"trendPerWeek"` // least-squares slope, points per week
+	Points       []MetricPoint `json:"points"`
+}
+
+type FillerPoint struct {
+	Start       string         `json:"start"`
+	Words       int            `json:"words"`
+	FillerCount int            `json:"fillerCount"`
+	Rate        *float64       `json:"rate"` // per 100 words
+	Fillers     map[string]int `json:"fillers"`
+}
+
+type FillerCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+type PaceBin struct {
+	Label string `json:"label"`
+	Min   int    `json:"min"`           // inclusive
+	Max   int    `json:"max,omitempty"` // exclusive; 0 for the last bin
+	Count int    `json:"count"`
+}
+
+type PaceStats struct {
+	Count        int       `json:"count"`
+	Mean         *float64  `json:"mean"`
+	P25          *float64  `json:"p25"`
+	Median       *float64  `json:"median"`
+	P75          *float64  `json:"p75"`
+	Distribution []PaceBin `json:"distribution"`
+}
+
+type ProgressStats struct {
+	From       string                   `json:"from"`
+	To         string                   `json:"to"`
+	Bucket     string                   `json:"bucket"`
+	Window     int                      `json:"window"`
+	Speeches   int                      `json:"speeches"`
+	Metrics    map[string]*MetricSeries `json:"metrics"`
+	Fillers    []FillerPoint            `json:"fillers"`
+	TopFillers []FillerCount            `json:"topFillers"`
+	Pace       PaceStats                `json:"pace"`
+}
+
+// statsSpeech is what the aggregation needs from one stored speech.
+type statsSpeech struct {
+	at     time.Time
+	scores map[string]float64
+	pace   int
+	local  *LocalMetrics
+}
+
+// bucketStart returns the first day of the bucket t falls into.
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if bucket == "week" {
+		t = t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	}
+	return t
+}
+
+func bucketStep(t time.Time, bucket string) time.Time {
+	if bucket == "week" {
+		return t.AddDate(0, 0, 7)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+func floatPtr(v float64) *float64 {
+	v = round3(v)
+	return &v
+}
+
+// GET /api/stats/progress?from=&to=&bucket=day|week&window= aggregates the
+// caller's speeches (not companion scorecards) over time. from/to are
+// inclusive dates; the default range is the last statsDefaultDays days.
+func handleProgressStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, "Method not allowed", 405)
+		return
+	}
+	uid := r.Context().Value(userIDKey).(int)
+	q := r.URL.Query()
+
+	to, ok := statsDate(w, q.Get("to"), "to", time.Now())
+	if !ok {
+		return
+	}
+	from, ok := statsDate(w, q.Get("from"), "from", to.AddDate(0, 0, -(statsDefaultDays-1)))
+	if !ok {
+		return
+	}
+	if to.Before(from) {
+		httpError(w, "from is after to", 400)
+		return
+	}
+
+	bucket := q.Get("bucket")
+	switch bucket {
+	case "":
+		bucket = "week"
+	case "day", "week":
+	default:
+		httpError(w, "bucket must be day or week", 400)
+		return
+	}
+	window := statsDefaultWindow
+	if v := q.Get("window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 52 {
+			httpError(w, "window must be 1-52", 400)
+			return
+		}
+		window = n
+	}
+
+	var starts []time.Time
+	for t := bucketStart(from, bucket); !t.After(to); t = bucketStep(t, bucket) {
+		starts = append(starts, t)
+		if len(starts) > statsMaxBuckets {
+			httpError(w, fmt.Sprintf("Range too large: at most %d buckets", statsMaxBuckets), 400)
+			return
+		}
+	}
+
+	speeches, err := loadStatsSpeeches(uid, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		log.Println("[!] Stats Query Error:", err)
+		httpError(w, "DB Query Error", 500)
+		return
+	}
+
+	res := ProgressStats{
+		From:     from.Format("2006-01-02"),
+		To:       to.Format("2006-01-02"),
+		Bucket:   bucket,
+		Window:   window,
+		Speeches: len(speeches),
+		Metrics:  map[string]*MetricSeries{},
+	}
+	index := map[time.Time]int{}
+	for i, t := range starts {
+		index[t] = i
+	}
+	for _, m := range statsMetrics {
+		res.Metrics[m] = metricSeries(speeches, m, starts, index, bucket, window)
+	}
+	res.Fillers, res.TopFillers = fillerSeries(speeches, starts, index, bucket)
+	res.Pace = paceStats(speeches)
+
+	jsonResponse(w, res)
+}
+
+// statsDate parses a YYYY-MM-DD parameter, or returns the day of def when
+// it is empty. It writes the error response itself.
+func statsDate(w http.ResponseWriter, v, name string, def time.Time) (time.Time, bool) {
+	if v == "" {
+		return bucketStart(def, "day"), true
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		httpError(w, fmt.Sprintf("Invalid %s: expected YYYY-MM-DD", name), 400)
+		return t, false
+	}
+	return t, true
+}
+
+func loadStatsSpeeches(uid int, from, until time.Time) ([]statsSpeech, error) {
+	rows, err := db.Query(`
+		SELECT created_at, clarity_score, pace_wpm, metrics, local_metrics FROM speeches
+		WHERE user_id = ? AND COALESCE(kind, 'speech') = 'speech' AND created_at >= ? AND created_at < ?
+		ORDER BY created_at`, uid, from.Format("2006-01-02"), until.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []statsSpeech
+	for rows.Next() {
+		var s statsSpeech
+		var clarity sql.NullInt64
+		var pace sql.NullInt64
+		var metStr, localStr sql.NullString
+		if err := rows.Scan(&s.at, &clarity, &pace, &metStr, &localStr); err != nil {
+			continue
+		}
+		s.scores = map[string]float64{}
+		json.Unmarshal([]byte(metStr.String), &s.scores)
+		if clarity.Valid {
+			s.scores["clarity"] = float64(clarity.Int64)
+		}
+		s.pace = int(pace.Int64)
+		// Speeches saved before local metrics existed have "{}" here.
+		var local LocalMetrics
+		if json.Unmarshal([]byte(localStr.String), &local) == nil && local.WordCount > 0 {
+			s.local = &local
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func metricSeries(speeches []statsSpeech, metric string, starts []time.Time, index map[time.Time]int, bucket string, window int) *MetricSeries {
+	sums := make([]float64, len(starts))
+	counts := make([]int, len(starts))
+	var xs, ys []float64
+	for _, s := range speeches {
+		v, ok := s.scores[metric]
+		if !ok {
+			continue
+		}
+		i, ok := index[bucketStart(s.at, bucket)]
+		if !ok {
+			continue
+		}
+		sums[i] += v
+		counts[i]++
+		xs = append(xs, s.at.Sub(starts[0]).Hours()/24)
+		ys = append(ys, v)
+	}
+
+	series := &MetricSeries{Points: make([]MetricPoint, len(starts))}
+	var recent []float64 // averages of the last buckets with data
+	for i, t := range starts {
+		p := MetricPoint{Start: t.Format("2006-01-02"), Count: counts[i]}
+		if counts[i] > 0 {
+			avg := sums[i] / float64(counts[i])
+			p.Average = floatPtr(avg)
+			recent = append(recent, avg)
+			if len(recent) > window {
+				recent = recent[1:]
+			}
+			p.Moving = floatPtr(mean(recent))
+		}
+		series.Points[i] = p
+	}
+	if len(ys) > 0 {
+		series.Average = floatPtr(mean(ys))
+	}
+	if slope, ok := linearSlope(xs, ys); ok {
+		series.TrendPerWeek = floatPtr(slope * 7)
+	}
+	return series
+}
+
+func fillerSeries(speeches []statsSpeech, starts []time.Time, index map[time.Time]int, bucket string) ([]FillerPoint, []FillerCount) {
+	points := make([]FillerPoint, len(starts))
+	for i, t := range starts {
+		points[i] = FillerPoint{Start: t.Format("2006-01-02"), Fillers: map[string]int{}}
+	}
+	totals := map[string]int{}
+	for _, s := range speeches {
+		i, ok := index[bucketStart(s.at, bucket)]
+		if !ok || s.local == nil {
+			continue
+		}
+		p := &points[i]
+		p.Words += s.local.WordCount
+		p.FillerCount += s.local.FillerCount
+		for f, n := range s.local.Fillers {
+			p.Fillers[f] += n
+			totals[f] += n
+		}
+	}
+	for i := range points {
+		if points[i].Words > 0 {
+			points[i].Rate = floatPtr(float64(points[i].FillerCount) * 100 / float64(points[i].Words))
+		}
+	}
+
+	top := make([]FillerCount, 0, len(totals))
+	for f, n := range totals {
+		top = append(top, FillerCount{Word: f, Count: n})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Word < top[j].Word
+	})
+	if len(top) > statsTopFillers {
+		top = top[:statsTopFillers]
+	}
+	return points, top
+}
+
+func paceStats(speeches []statsSpeech) PaceStats {
+	bins := []PaceBin{{Label: fmt.Sprintf("<%d", paceBinMin), Min: 0, Max: paceBinMin}}
+	for lo := paceBinMin; lo < paceBinMax; lo += paceBinWidth {
+		bins = append(bins, PaceBin{Label: fmt.Sprintf("%d-%d", lo, lo+paceBinWidth), Min: lo, Max: lo + paceBinWidth})
+	}
+	bins = append(bins, PaceBin{Label: fmt.Sprintf("%d+", paceBinMax), Min: paceBinMax})
+
+	var paces []float64
+	for _, s := range speeches {
+		// 0 means the pace could not be measured.
+		if s.pace <= 0 {
+			continue
+		}
+		paces = append(paces, float64(s.pace))
+		i := 0
+		if s.pace >= paceBinMax {
+			i = len(bins) - 1
+		} else if s.pace >= paceBinMin {
+			i = 1 + (s.pace-paceBinMin)/paceBinWidth
+		}
+		bins[i].Count++
+	}
+
+	ps := PaceStats{Count: len(paces), Distribution: bins}
+	if len(paces) > 0 {
+		sort.Float64s(paces)
+		ps.Mean = floatPtr(mean(paces))
+		ps.P25 = floatPtr(percentile(paces, 0.25))
+		ps.Median = floatPtr(percentile(paces, 0.5))
+		ps.P75 = floatPtr(percentile(paces, 0.75))
+	}
+	return ps
+}
+
+func mean(v []float64) float64 {
+	sum := 0.0
+	for _, x := range v {
+		sum += x
+	}
+	return sum / float64(len(v))
+}
+
+// percentile interpolates linearly between the closest ranks of sorted.
+func percentile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := min(lo+1, len(sorted)-1)
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// linearSlope is the least-squares slope of y over x. It needs at least two
+// distinct x values.
+func linearSlope(xs, ys []float64) (float64, bool) {
+	if len(xs) < 2 {
+		return 0, false
+	}
+	mx, my := mean(xs), mean(ys)
+	var num, den float64
+	for i := range xs {
+		num += (xs[i] - mx) * (ys[i] - my)
+		den += (xs[i] - mx) * (xs[i] - mx)
+	}
+	if den == 0 {
+		return 0, false
+	}
+	return num / den, true
+}
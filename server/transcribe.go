@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Uploads above this size are rejected before they reach the backend.
+const maxAudioBytes = 25 << 20
+
+// Transcriber turns recorded audio into text with word timings, replacing
+// the browser-only webkitSpeechRecognition.
+type Transcriber interface {
+	Name() string
+	Transcribe(ctx context.Context, audio []byte, format, lang string) (*Transcript, error)
+}
+
+type Transcript struct {
+	Text     string           `json:"text"`
+	Words    []TranscriptWord `json:"words"`
+	Duration float64          `json:"duration"`
+}
+
+type TranscriptWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+var transcriber Transcriber
+
+// initSTT picks the speech-to-text backend from STT_PROVIDER (whisper | fake).
+// Without it the audio endpoint answers 501, like unconfigured OAuth.
+func initSTT() {
+	provider := os.Getenv("STT_PROVIDER")
+	if provider == "" && os.Getenv("WHISPER_URL") != "" {
+		provider = "whisper"
+	}
+
+	switch provider {
+	case "":
+		return
+	case "whisper":
+		url := os.Getenv("WHISPER_URL")
+		if url == "" {
+			url = "http://127.0.0.1:8080"
+		}
+		transcriber = &whisperTranscriber{
+			url:    strings.TrimRight(url, "/"),
+			client: &http.Client{Timeout: 5 * time.Minute},
+		}
+	case "fake":
+		transcriber = fakeTranscriber{}
+	default:
+		log.Fatal("[!] Unknown STT_PROVIDER: ", provider)
+	}
+
+	fmt.Printf("[+] STT provider: %s\n", transcriber.Name())
+}
+
+// audioFormat sniffs the container from magic bytes; the client-supplied
+// content type is not trusted.
+func audioFormat(b []byte) string {
+	switch {
+	case len(b) >= 12 && string(b[0:4]) == "RIFF" && string(b[8:12]) == "WAVE":
+		return "wav"
+	case len(b) >= 4 && bytes.Equal(b[0:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return "webm"
+	case len(b) >= 4 && string(b[0:4]) == "OggS":
+		return "ogg"
+	}
+	return ""
+}
+
+// POST /api/speeches/audio (multipart: audio, language, durationSeconds,
+// scriptId, topicId).
+// The transcript goes through the same job queue as /api/analyze, ?async=1
+// included.
+func handleAudioUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		httpError(w, "Method not allowed", 405)
+		return
+	}
+	if transcriber == nil {
+		httpError(w, "Speech-to-text not configured", 501)
+		return
+	}
+	uid := r.Context().Value(userIDKey).(int)
+	if !checkQuota(w, uid) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAudioBytes+1<<20)
+	if err := r.ParseMultipartForm(maxAudioBytes); err != nil {
+		httpError(w, "Файл слишком большой или поврежден", 400)
+		return
+	}
+	file, _, err := r.FormFile("audio")
+	if err != nil {
+		httpError(w, "No audio file", 400)
+		return
+	}
+	defer file.Close()
+
+	audio, err := io.ReadAll(io.LimitReader(file, maxAudioBytes+1))
+	if err != nil || len(audio) > maxAudioBytes {
+		httpError(w, "Файл слишком большой", 400)
+		return
+	}
+	format := audioFormat(audio)
+	if format == "" {
+		httpError(w, "Unsupported audio format (WAV, WebM, OGG)", 415)
+		return
+	}
+
+	var scriptID int64
+	langCode := r.FormValue("language")
+	if v := r.FormValue("scriptId"); v != "" {
+		id, _ := strconv.ParseInt(v, 10, 64)
+		s, err := loadScript(uid, id)
+		if err != nil {
+			httpError(w, "Script not found", 404)
+			return
+		}
+		scriptID = s.ID
+		if strings.TrimSpace(langCode) == "" {
+			langCode = s.Language
+		}
+	}
+	topicID, _ := strconv.ParseInt(r.FormValue("topicId"), 10, 64)
+	if topicID != 0 && !topicExists(topicID) {
+		httpError(w, "Unknown topic", 400)
+		return
+	}
+	language, ok := requireLanguage(w, langCode)
+	if !ok {
+		return
+	}
+	lang := language.Code
+
+	duration, _ := strconv.ParseFloat(r.FormValue("durationSeconds"), 64)
+	if msg := validateTimings(nil, duration); msg != "" {
+		httpError(w, msg, 400)
+		return
+	}
+
+	tr, err := transcriber.Transcribe(r.Context(), audio, format, lang)
+	if err != nil {
+		log.Println("[!] STT Error:", err)
+		httpError(w, "Ошибка распознавания речи", 502)
+		return
+	}
+	if len(strings.TrimSpace(tr.Text)) < 2 {
+		httpError(w, "Речь не распознана", 422)
+		return
+	}
+
+	if duration <= 0 {
+		duration = tr.Duration
+	}
+	// Timings the backend got wrong only cost the pace analysis, not the
+	// upload.
+	if msg := validateTimings(tr.Words, duration); msg != "" {
+		log.Println("[!] STT Timings Dropped:", msg)
+		tr.Words = nil
+	}
+
+	// From here on it is an ordinary analysis job: the transcription is
+	// paid for, so it must survive timeouts and restarts.
+	submitAnalysis(w, r, uid, AnalyzeRequest{
+		Transcript: tr.Text,
+		Duration:   duration,
+		Language:   lang,
+		Words:      tr.Words,
+		ScriptID:   scriptID,
+		TopicID:    topicID,
+	}, tr.Text)
+}
+
+// --- whisper.cpp server ---
+
+// whisperTranscriber talks to whisper.cpp's bundled HTTP server
+// (`whisper-server`). Start it with --convert to accept WebM/OGG via ffmpeg.
+type whisperTranscriber struct {
+	url    string
+	client *http.Client
+}
+
+func (t *whisperTranscriber) Name() string { return "whisper" }
+
+func (t *whisperTranscriber) Transcribe(ctx context.Context, audio []byte, format, lang string) (*Transcript, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "speech."+format)
+	fw.Write(audio)
+	mw.WriteField("response_format", "verbose_json")
+	mw.WriteField("language", lang)
+	mw.WriteField("temperature", "0")
+	mw.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", t.url+"/inference", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("whisper: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return parseWhisperResponse(resp.Body)
+}
+
+// parseWhisperResponse reads a verbose_json response. Word timings are kept
+// only when the server measured them for every segment and they are sane:
+// older servers time segments only, and timings spread over a segment would
+// pass invented pauses and pace off as measured ones.
+func parseWhisperResponse(r io.Reader) (*Transcript, error) {
+	var data struct {
+		Text     string  `json:"text"`
+		Duration float64 `json:"duration"`
+		Segments []struct {
+			Text  string           `json:"text"`
+			Start float64          `json:"start"`
+			End   float64          `json:"end"`
+			Words []TranscriptWord `json:"words"`
+		} `json:"segments"`
+	}
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, fmt.Errorf("whisper: bad response: %w", err)
+	}
+
+	tr := &Transcript{Text: strings.TrimSpace(data.Text), Duration: data.Duration}
+	for _, seg := range data.Segments {
+		if len(seg.Words) == 0 && strings.TrimSpace(seg.Text) != "" {
+			tr.Words = nil
+			break
+		}
+		for _, w := range seg.Words {
+			w.Word = strings.TrimSpace(w.Word)
+			if w.Word != "" {
+				tr.Words = append(tr.Words, w)
+			}
+		}
+	}
+	if !wordsInOrder(tr.Words) {
+		tr.Words = nil
+	}
+	if tr.Duration == 0 && len(data.Segments) > 0 {
+		tr.Duration = data.Segments[len(data.Segments)-1].End
+	}
+	return tr, nil
+}
+
+// wordsInOrder reports whether timings are non-negative, each word ends
+// after it starts and no word starts before the previous one.
+func wordsInOrder(words []TranscriptWord) bool {
+	prev := 0.0
+	for _, w := range words {
+		if w.Start < prev || w.End < w.Start {
+			return false
+		}
+		prev = w.Start
+	}
+	return true
+}
+
+// --- Fake ---
+
+// fakeTranscriber returns a fixed transcript at 120 WPM so the upload path
+// can be exercised without a model.
+type fakeTranscriber struct{}
+
+func (fakeTranscriber) Name() string { return "fake" }
+
+func (fakeTranscriber) Transcribe(ctx context.Context, audio []byte, format, lang string) (*Transcript, error) {
+	text := languageFor(lang).FakeTranscript
+	tr := &Transcript{Text: text}
+	for i, w := range strings.Fields(text) {
+		start := float64(i) * 0.5
+		tr.Words = append(tr.Words, TranscriptWord{Word: w, Start: start, End: start + 0.4})
+	}
+	tr.Duration = float64(len(tr.Words)) * 0.5
+	return tr, nil
+}
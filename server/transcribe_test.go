@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWhisperResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		text     string
+		duration float64
+		words    []TranscriptWord
+	}{
+		{
+			name: "word timings",
+			body: `{"text":" Hello there. Bye.","duration":3.5,"segments":[
+				{"text":" Hello there.","start":0,"end":1.2,"words":[{"word":" Hello","start":0.1,"end":0.5},{"word":" there.","start":0.6,"end":1.1}]},
+				{"text":" Bye.","start":2,"end":3,"words":[{"word":" Bye.","start":2.2,"end":2.6},{"word":" ","start":2.6,"end":2.7}]}]}`,
+			text:     "Hello there. Bye.",
+			duration: 3.5,
+			words:    []TranscriptWord{{"Hello", 0.1, 0.5}, {"there.", 0.6, 1.1}, {"Bye.", 2.2, 2.6}},
+		},
+		{
+			name: "segment timings only",
+			body: `{"text":"Hello there. Bye.","segments":[
+				{"text":"Hello there.","start":0,"end":1.2},
+				{"text":"Bye.","start":2,"end":3}]}`,
+			text:     "Hello there. Bye.",
+			duration: 3,
+		},
+		{
+			name: "some segments without words",
+			body: `{"text":"Hello there. Bye.","duration":3,"segments":[
+				{"text":"Hello there.","start":0,"end":1.2,"words":[{"word":"Hello","start":0.1,"end":0.5},{"word":"there.","start":0.6,"end":1.1}]},
+				{"text":"Bye.","start":2,"end":3}]}`,
+			text:     "Hello there. Bye.",
+			duration: 3,
+		},
+		{
+			name: "words out of order",
+			body: `{"text":"Hello there.","duration":2,"segments":[
+				{"text":"Hello there.","start":0,"end":2,"words":[{"word":"Hello","start":1.0,"end":1.4},{"word":"there.","start":0.2,"end":0.6}]}]}`,
+			text:     "Hello there.",
+			duration: 2,
+		},
+		{
+			name: "negative timestamp",
+			body: `{"text":"Hello.","duration":1,"segments":[
+				{"text":"Hello.","start":0,"end":1,"words":[{"word":"Hello.","start":-0.3,"end":0.4}]}]}`,
+			text:     "Hello.",
+			duration: 1,
+		},
+		{
+			name: "word ends before it starts",
+			body: `{"text":"Hello.","duration":1,"segments":[
+				{"text":"Hello.","start":0,"end":1,"words":[{"word":"Hello.","start":0.5,"end":0.2}]}]}`,
+			text:     "Hello.",
+			duration: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := parseWhisperResponse(strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tr.Text != tt.text || tr.Duration != tt.duration {
+				t.Errorf("text %q, duration %v; want %q, %v", tr.Text, tr.Duration, tt.text, tt.duration)
+			}
+			if !reflect.DeepEqual(tr.Words, tt.words) {
+				t.Errorf("words = %v, want %v", tr.Words, tt.words)
+			}
+		})
+	}
+
+	if _, err := parseWhisperResponse(strings.NewReader("not json")); err == nil {
+		t.Error("malformed response accepted")
+	}
+}
+
+func TestWhisperTranscribe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/inference" || r.FormValue("language") != "en" || r.FormValue("response_format") != "verbose_json" {
+			http.Error(w, "bad request", 400)
+			return
+		}
+		w.Write([]byte(`{"text":"Hi.","duration":1,"segments":[{"text":"Hi.","start":0,"end":1,"words":[{"word":"Hi.","start":0.1,"end":0.3}]}]}`))
+	}))
+	defer srv.Close()
+
+	tr, err := (&whisperTranscriber{url: srv.URL, client: srv.Client()}).Transcribe(context.Background(), []byte("RIFF"), "wav", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Text != "Hi." || len(tr.Words) != 1 {
+		t.Fatalf("got %+v", tr)
+	}
+
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", 503)
+	}))
+	defer fail.Close()
+	if _, err := (&whisperTranscriber{url: fail.URL, client: fail.Client()}).Transcribe(context.Background(), []byte("RIFF"), "wav", "en"); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("err = %v, want status 503", err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default token quotas per user; 0 means unlimited. Per-user overrides live
+// in users.daily_token_quota / users.monthly_token_quota.
+var (
+	defaultDailyTokens   int64
+	defaultMonthlyTokens int64
+)
+
+type usageKey struct{}
+
+// usageTag tells the metered provider whom to bill a call to.
+type usageTag struct {
+	userID  int
+	feature string
+}
+
+// withUsage tags ctx so LLM calls made with it are recorded against the user
+// under the given feature (analysis, companion, ...).
+func withUsage(ctx context.Context, uid int, feature string) context.Context {
+	return context.WithValue(ctx, usageKey{}, usageTag{userID: uid, feature: feature})
+}
+
+func initUsage() {
+	defaultDailyTokens = envInt64("USAGE_DAILY_TOKENS")
+	defaultMonthlyTokens = envInt64("USAGE_MONTHLY_TOKENS")
+	llm = meteredProvider{inner: llm}
+	fmt.Printf("[+] Token quotas: daily %d, monthly %d (0 = unlimited)\n", defaultDailyTokens, defaultMonthlyTokens)
+}
+
+func envInt64(name string) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		log.Fatal("[!] Invalid ", name, ": ", v)
+	}
+	return n
+}
+
+// meteredProvider records every call of the wrapped provider in llm_usage.
+type meteredProvider struct {
+	inner LLMProvider
+}
+
+func (m meteredProvider) Name() string         { return m.inner.Name() }
+func (m meteredProvider) DefaultModel() string { return m.inner.DefaultModel() }
+
+func (m meteredProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	start := time.Now()
+	resp, err := m.inner.Generate(ctx, req)
+	m.record(ctx, req, resp, err, start)
+	return resp, err
+}
+
+// Stream keeps the text received so far: a stream that breaks off, or whose
+// client disconnects, returns no response but was still generated.
+func (m meteredProvider) Stream(ctx context.Context, req LLMRequest, onChunk func(string) error) (*LLMResponse, error) {
+	start := time.Now()
+	var partial strings.Builder
+	resp, err := m.inner.Stream(ctx, req, func(chunk string) error {
+		partial.WriteString(chunk)
+		return onChunk(chunk)
+	})
+	used := resp
+	if used == nil {
+		used = &LLMResponse{PromptTokens: requestTokens(req), ResponseTokens: estimateTokens(partial.String())}
+	}
+	m.record(ctx, req, used, err, start)
+	return resp, err
+}
+
+// requestTokens estimates the prompt side of a call when the backend does
+// not report it.
+func requestTokens(req LLMRequest) int {
+	n := estimateTokens(req.System + req.Prompt)
+	for _, m := range req.History {
+		n += estimateTokens(m.Text)
+	}
+	return n
+}
+
+func (m meteredProvider) record(ctx context.Context, req LLMRequest, resp *LLMResponse, err error, start time.Time) {
+	tag, _ := ctx.Value(usageKey{}).(usageTag)
+	if tag.feature == "" {
+		tag.feature = "other"
+	}
+	model := req.Config.Model
+	if model == "" {
+		model = m.inner.DefaultModel()
+	}
+	var promptTokens, responseTokens int
+	if resp != nil {
+		promptTokens, responseTokens = resp.PromptTokens, resp.ResponseTokens
+	}
+
+	// Calls that finish after their user's account was deleted are not kept.
+	uid := sql.NullInt64{Int64: int64(tag.userID), Valid: tag.userID != 0}
+	_, dbErr := db.Exec(`INSERT INTO llm_usage (user_id, feature, provider, model, prompt_tokens, response_tokens, latency_ms, success)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ? WHERE ? IS NULL OR EXISTS (SELECT 1 FROM users WHERE id = ?)`,
+		uid, tag.feature, m.inner.Name(), model,
+		promptTokens, responseTokens, time.Since(start).Milliseconds(), err == nil, uid, uid)
+	if dbErr != nil {
+		log.Println("[!] Usage Save Error:", dbErr)
+	}
+}
+
+// UsagePeriod is the consumption of one quota window.
+type UsagePeriod struct {
+	Used    int64     `json:"used"`
+	Limit   int64     `json:"limit"` // 0 = unlimited
+	ResetAt time.Time `json:"resetAt"`
+}
+
+// Quota windows are calendar based in UTC: the day resets at midnight, the
+// month on the 1st.
+func usageWindows(now time.Time) (dayStart, dayEnd, monthStart, monthEnd time.Time) {
+	now = now.UTC()
+	dayStart = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return dayStart, dayStart.AddDate(0, 0, 1), monthStart, monthStart.AddDate(0, 1, 0)
+}
+
+func usedTokens(uid int, since time.Time) int64 {
+	var n int64
+	db.QueryRow(`SELECT COALESCE(SUM(prompt_tokens + response_tokens), 0) FROM llm_usage
+		WHERE user_id = ? AND created_at >= ?`, uid, since.Format("2006-01-02 15:04:05")).Scan(&n)
+	return n
+}
+
+func userQuotas(uid int) (daily, monthly int64) {
+	var d, m sql.NullInt64
+	db.QueryRow(`SELECT daily_token_quota, monthly_token_quota FROM users WHERE id = ?`, uid).Scan(&d, &m)
+	daily, monthly = defaultDailyTokens, defaultMonthlyTokens
+	if d.Valid {
+		daily = d.Int64
+	}
+	if m.Valid {
+		monthly = m.Int64
+	}
+	return daily, monthly
+}
+
+func userUsage(uid int) (day, month UsagePeriod) {
+	dayStart, dayEnd, monthStart, monthEnd := usageWindows(time.Now())
+	daily, monthly := userQuotas(uid)
+	day = UsagePeriod{Used: usedTokens(uid, dayStart), Limit: daily, ResetAt: dayEnd}
+	month = UsagePeriod{Used: usedTokens(uid, monthStart), Limit: monthly, ResetAt: monthEnd}
+	return day, month
+}
+
+// checkQuota answers 429 with the reset time when the user has used up a
+// quota. A call that starts under the limit may overshoot it; the next one
+// is refused.
+func checkQuota(w http.ResponseWriter, uid int) bool {
+	day, month := userUsage(uid)
+	for _, p := range []struct {
+		name string
+		UsagePeriod
+	}{{"month", month}, {"day", day}} {
+		if p.Limit == 0 || p.Used < p.Limit {
+			continue
+		}
+		retry := int(time.Until(p.ResetAt).Seconds()) + 1
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Лимит запросов к ИИ исчерпан",
+			"period":  p.name,
+			"limit":   p.Limit,
+			"used":    p.Used,
+			"resetAt": p.ResetAt,
+		})
+		return false
+	}
+	return true
+}
+
+// GET /api/usage
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	uid := r.Context().Value(userIDKey).(int)
+
+	day, month := userUsage(uid)
+	_, _, monthStart, _ := usageWindows(time.Now())
+
+	type featureUsage struct {
+		Feature        string `json:"feature"`
+		Calls          int    `json:"calls"`
+		PromptTokens   int64  `json:"promptTokens"`
+		ResponseTokens int64  `json:"responseTokens"`
+	}
+	features := []featureUsage{}
+	rows, err := db.Query(`
+		SELECT feature, COUNT(*), COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(response_tokens), 0)
+		FROM llm_usage
+		WHERE user_id = ? AND created_at >= ?
+		GROUP BY feature
+		ORDER BY feature`, uid, monthStart.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		httpError(w, "DB Error", 500)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f featureUsage
+		if rows.Scan(&f.Feature, &f.Calls, &f.PromptTokens, &f.ResponseTokens) == nil {
+			features = append(features, f)
+		}
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"day":      day,
+		"month":    month,
+		"features": features,
+	})
+}
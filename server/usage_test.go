@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// A client that disconnects mid-stream is still charged for the prompt and
+// for the text it received.
+func TestInterruptedStreamIsMetered(t *testing.T) {
+	uid := setupTestDB(t)
+	m := meteredProvider{inner: fakeProvider{}}
+	ctx := withUsage(context.Background(), uid, "companion")
+	req := LLMRequest{System: "You are a conversation partner.", Prompt: "Tell me about your weekend, please."}
+
+	gone := errors.New("client disconnected")
+	chunks := 0
+	resp, err := m.Stream(ctx, req, func(string) error {
+		if chunks++; chunks == 2 {
+			return gone
+		}
+		return nil
+	})
+	if resp != nil || !errors.Is(err, gone) {
+		t.Fatalf("Stream = %v, %v; want nil, %v", resp, err, gone)
+	}
+
+	var prompt, response int
+	var success bool
+	if err := db.QueryRow(`SELECT prompt_tokens, response_tokens, success FROM llm_usage WHERE user_id = ?`, uid).
+		Scan(&prompt, &response, &success); err != nil {
+		t.Fatal(err)
+	}
+	if prompt != requestTokens(req) || response == 0 || success {
+		t.Fatalf("recorded prompt=%d response=%d success=%v", prompt, response, success)
+	}
+	dayStart, _, _, _ := usageWindows(time.Now())
+	if used := usedTokens(uid, dayStart); used != int64(prompt+response) {
+		t.Fatalf("usedTokens = %d, want %d", used, prompt+response)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 	"time"
@@ -10,17 +11,27 @@ func handleHistory(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(userIDKey).(int)
 
 	if r.Method == "DELETE" {
-		_, err := db.Exec("DELETE FROM speeches WHERE user_id = ?", userID)
+		tx, err := db.Begin()
 		if err != nil {
 			httpError(w, "Failed to delete history", 500)
 			return
 		}
+		defer tx.Rollback()
+		if _, err := deleteSpeeches(tx, "user_id = ?", userID); err != nil {
+			httpError(w, "Failed to delete history", 500)
+			return
+		}
+		// Failed jobs never got a speech but still hold a transcript.
+		if _, err := tx.Exec(`DELETE FROM analysis_jobs WHERE user_id = ? AND status IN ('done', 'failed')`, userID); err != nil || tx.Commit() != nil {
+			httpError(w, "Failed to delete history", 500)
+			return
+		}
 		jsonResponse(w, map[string]string{"msg": "History cleared"})
 		return
 	}
 
 	rows, err := db.Query(`
-		SELECT id, transcript, clarity_score, pace_wpm, filler_words, feedback, tip, metrics, created_at 
+		SELECT id, transcript, clarity_score, pace_wpm, filler_words, feedback, tip, metrics, local_metrics, pace_analysis, prompt_version, kind, script_id, rehearsal, created_at 
 		FROM speeches 
 		WHERE user_id = ? 
 		ORDER BY created_at DESC`, userID)
@@ -36,9 +47,11 @@ func handleHistory(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var id, cl, pm int
 		var tr, fw, fb, tp, metStr string
+		var localStr, paceStr, promptVersion, kind, rehearsalStr sql.NullString
+		var scriptID sql.NullInt64
 		var dt time.Time
 
-		if err := rows.Scan(&id, &tr, &cl, &pm, &fw, &fb, &tp, &metStr, &dt); err != nil {
+		if err := rows.Scan(&id, &tr, &cl, &pm, &fw, &fb, &tp, &metStr, &localStr, &paceStr, &promptVersion, &kind, &scriptID, &rehearsalStr, &dt); err != nil {
 			continue
 		}
 
@@ -54,17 +67,40 @@ func handleHistory(w http.ResponseWriter, r *http.Request) {
 		}
 		_ = json.Unmarshal([]byte(metStr), &metricsObj)
 
-		res = append(res, map[string]interface{}{
-			"id":           id,
-			"transcript":   tr,
-			"clarityScore": cl,
-			"pace":         pm,
-			"fillerWords":  fwArr,
-			"feedback":     fb,
-			"tip":          tp,
-			"metrics":      metricsObj,
-			"date":         dt,
-		})
+		var localObj map[string]interface{}
+		if localStr.String == "" {
+			localStr.String = "{}"
+		}
+		_ = json.Unmarshal([]byte(localStr.String), &localObj)
+
+		var paceObj map[string]interface{}
+		if paceStr.String == "" {
+			paceStr.String = "{}"
+		}
+		_ = json.Unmarshal([]byte(paceStr.String), &paceObj)
+
+		item := map[string]interface{}{
+			"id":            id,
+			"transcript":    tr,
+			"clarityScore":  cl,
+			"pace":          pm,
+			"fillerWords":   fwArr,
+			"feedback":      fb,
+			"tip":           tp,
+			"metrics":       metricsObj,
+			"localMetrics":  localObj,
+			"paceAnalysis":  paceObj,
+			"promptVersion": promptVersion.String,
+			"kind":          kind.String,
+			"date":          dt,
+		}
+		if scriptID.Valid {
+			var rehearsalObj map[string]interface{}
+			_ = json.Unmarshal([]byte(rehearsalStr.String), &rehearsalObj)
+			item["scriptId"] = scriptID.Int64
+			item["rehearsal"] = rehearsalObj
+		}
+		res = append(res, item)
 	}
 
 	if res == nil {
@@ -76,6 +112,10 @@ func handleHistory(w http.ResponseWriter, r *http.Request) {
 
 func handleGetProfile(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(userIDKey).(int)
+	lang, ok := requireLanguage(w, r.URL.Query().Get("lang"))
+	if !ok {
+		return
+	}
 
 	var u UserProfile
 	var badgesStr string
@@ -94,7 +134,7 @@ func handleGetProfile(w http.ResponseWriter, r *http.Request) {
 	json.Unmarshal([]byte(badgesStr), &u.Badges)
 
 	u.NextLvlXP = u.Level * 1000
-	u.Title = getTitleByLevel(u.Level)
+	u.Title = lang.title(u.Level)
 
 	jsonResponse(w, u)
 }
@@ -15,7 +15,10 @@ import (
 // Type-safe context key
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey      contextKey = "userID"
+	tokenIssuedKey contextKey = "tokenIssued" // time.Time; zero for tokens without iat
+)
 
 func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -40,7 +43,17 @@ func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			ctx := context.WithValue(r.Context(), userIDKey, int(claims["id"].(float64)))
+			uid := int(claims["id"].(float64))
+			// Tokens of deleted accounts stay signed until they expire.
+			var exists int
+			if db.QueryRow(`SELECT 1 FROM users WHERE id = ?`, uid).Scan(&exists) != nil {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			ctx := context.WithValue(r.Context(), userIDKey, uid)
+			if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+				ctx = context.WithValue(ctx, tokenIssuedKey, iat.Time)
+			}
 			next(w, r.WithContext(ctx))
 		} else {
 			w.WriteHeader(http.StatusForbidden)
@@ -52,28 +65,38 @@ func makeToken(id int, name string) string {
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":       id,
 		"username": name,
+		"iat":      time.Now().Unix(),
 		"exp":      time.Now().Add(24 * time.Hour).Unix(),
 	})
 	s, _ := t.SignedString(jwtSecret)
 	return s
 }
 
-func sendTg(chatID int64, code, actionRu string) bool {
+// sendTg sends a one-time code for action ("register" | "login"). Without
+// a known language the message repeats the line in every language of
+// otpLanguageOrder.
+func sendTg(chatID int64, code, action, lang string) bool {
 	if bot == nil {
 		return false
 	}
 
-	var actionEn string
-	switch actionRu {
-	case "регистрации":
-		actionEn = "registration"
-	case "входа":
-		actionEn = "login"
-	default:
-		actionEn = "action"
+	langs := otpLanguageOrder
+	if l, ok := findLanguage(lang); ok && lang != "" {
+		langs = []string{l.Code}
+	}
+
+	var lines, warnings []string
+	for _, c := range langs {
+		l := languageFor(c)
+		name, ok := l.OTPActions[action]
+		if !ok {
+			name = action
+		}
+		lines = append(lines, fmt.Sprintf(l.OTPLine, name, code))
+		warnings = append(warnings, l.OTPWarning)
 	}
 
-	txt := fmt.Sprintf("🔐 <b>Orato AI</b>\n\nYour code for %s: <code>%s</code>\nВаш код для %s: <code>%s</code>\n\nDo not share this / Никому не сообщайте.", actionEn, code, actionRu, code)
+	txt := "🔐 <b>Orato AI</b>\n\n" + strings.Join(lines, "\n") + "\n\n" + strings.Join(warnings, " / ")
 	msg := tgbotapi.NewMessage(chatID, txt)
 	msg.ParseMode = "HTML"
 	_, err := bot.Send(msg)